@@ -1,93 +1,322 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/config"
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/metrics"
 	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
 	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/routes"
 	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/tracing"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 )
 
+// accessLogDrainTimeout bounds writing out the access log at shutdown, after
+// the other queues have had their turn.
+const accessLogDrainTimeout = 5 * time.Second
+
+// Version is set at build time with -ldflags "-X main.Version=...".
+var Version = "dev"
+
 var (
-	DB                 *Storage.URLDB
-	GetURLRateLimit    *middlewares.Ratelimiter
-	CreateURLRateLimit *middlewares.Ratelimiter
+	DB         *Storage.URLDB
+	Importer   *handlers.Importer
+	RateLimits *middlewares.RateLimiters
+	AccessLog  *middlewares.AccessLog
+	AdminToken string
 )
 
-func Setup() {
-	user := os.Getenv("POSTGRES_USER")
-	password := os.Getenv("POSTGRES_PASSWORD")
-	host := os.Getenv("POSTGRES_HOST")
-	pport := os.Getenv("POSTGRES_PORT")
-	dbname := os.Getenv("POSTGRES_DB")
-	Redis_host := os.Getenv("REDIS_HOST")
-	Redis_port := os.Getenv("REDIS_PORT")
-	if Redis_port == "" {
-		Redis_port = "6379"
-	}
-	sslmode := "disable"
-
-	connURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", user, password, host, pport, dbname, sslmode)
-	Redis_URL := fmt.Sprintf("%s:%s", Redis_host, Redis_port)
+func Setup(cfg *config.Config) {
 	var err error
-	DB, err = Storage.ConnectToDB(connURL, Redis_URL)
+	DB, err = Storage.ConnectToDB(cfg.Postgres.DSN(), cfg.Redis.Addr(), Storage.Options{
+		Cache: Storage.CacheOptions{
+			MaxEntries: cfg.Cache.MaxEntries,
+			MaxBytes:   cfg.Cache.MaxBytes,
+		},
+		TTLs:            cacheTTLs(cfg.Cache),
+		InsertWorkers:   cfg.Storage.InsertWorkers,
+		InsertQueueSize: cfg.Storage.InsertQueueSize,
+	})
 	if err != nil {
-		log.Fatal(err)
+		fatal("connecting to storage failed", err)
 	}
 	err = DB.CreateTables()
 	if err != nil {
-		log.Fatal(err)
+		fatal("creating tables failed", err)
+	}
+	Importer = handlers.NewImporter(DB, cfg.Imports.Workers, cfg.Imports.QueueSize)
+	AdminToken = cfg.Admin.Token
+	go warmCache()
+	err = setupGenerators(cfg.ShortCodes)
+	if err != nil {
+		fatal("configuring short code generators failed", err)
+	}
+	RateLimits, err = middlewares.NewRateLimiters(cfg.Redis.Addr(), cfg.RateLimits)
+	if err != nil {
+		fatal("setting up rate limiters failed", err)
+	}
+	utils.SetBlockedDomains(cfg.BlockedDomains)
+	if cfg.AccessLog.Path != "" {
+		AccessLog, err = middlewares.NewAccessLog(middlewares.AccessLogOptions{
+			Path:       cfg.AccessLog.Path,
+			Format:     cfg.AccessLog.Format,
+			BufferSize: cfg.AccessLog.BufferSize,
+			OnFull:     cfg.AccessLog.OnFull,
+			Rotation: middlewares.RotationOptions{
+				MaxSize:    cfg.AccessLog.MaxSize,
+				MaxAge:     cfg.AccessLog.RotateEvery,
+				MaxBackups: cfg.AccessLog.MaxBackups,
+				Compress:   cfg.AccessLog.Compress,
+			},
+		})
+		if err != nil {
+			fatal("opening access log failed", err)
+		}
+	}
+}
+
+// applyReload pushes the settings that can change at runtime to the parts
+// that use them.
+func applyReload(cfg *config.Config) {
+	if err := RateLimits.Update(cfg.RateLimits); err != nil {
+		slog.Error("rate limit reload failed", "err", err)
+	}
+	DB.SetCacheTTLs(cacheTTLs(cfg.Cache))
+	if err := utils.SetLogLevel(cfg.Log.Level); err != nil {
+		slog.Error("log level reload failed", "err", err)
+	}
+	utils.SetBlockedDomains(cfg.BlockedDomains)
+}
+
+func cacheTTLs(cfg config.CacheConfig) Storage.CacheTTLs {
+	return Storage.CacheTTLs{
+		Local:    cfg.LocalTTL,
+		Fallback: cfg.FallbackTTL,
+		Negative: cfg.NegativeTTL,
+		Redis:    cfg.RedisTTL,
+	}
+}
+
+func setupGenerators(cfg config.ShortCodeConfig) error {
+	alphabet := cfg.Alphabet
+	switch alphabet {
+	case "":
+		alphabet = handlers.Alphabet
+	case "safe":
+		alphabet = handlers.SafeAlphabet
+	}
+	err := handlers.ConfigureGenerators(alphabet, cfg.Length, cfg.Salt, DB.NextCodeSequence)
+	if err != nil {
+		return err
+	}
+	if cfg.Strategy != "" {
+		return handlers.SetDefaultGenerator(cfg.Strategy)
 	}
-	GetURLRateLimit = middlewares.NewRateLimiter(Redis_URL, 1000000000000000000, time.Minute)
-	CreateURLRateLimit = middlewares.NewRateLimiter(Redis_URL, 1000000000000000000, time.Minute)
+	return nil
+}
+
+// warmCache preloads the codes other replicas have been serving most, so a
+// fresh replica doesn't send all of them to Postgres at once.
+func warmCache() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	n, err := DB.WarmCache(ctx, Storage.WarmCacheSize)
+	if err != nil {
+		slog.Error("cache warm failed", "err", err)
+		return
+	}
+	slog.Info("warmed cache", "hot_links", n)
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
 func main() {
-	middlewares.StartAsyncStreamLogger(1000)
+	loader, err := config.NewLoader(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := utils.SetupLogger(os.Stderr, cfg.Log.Format, cfg.Log.Level); err != nil {
+		log.Fatal(err)
+	}
 
-	Setup()
+	stopTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		ServiceName: cfg.Tracing.ServiceName,
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal("setting up tracing failed", err)
+	}
 
-	defer func() {
-		err := DB.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-		middlewares.StopAsyncStreamLogger()
-	}()
+	Setup(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	watcher := config.NewWatcher(loader, cfg)
+	watcher.OnReload(applyReload)
+	go watcher.Run(ctx)
+
+	registry := metrics.NewRegistry()
+	metrics.RegisterStorage(registry, DB)
+	metrics.RegisterRateLimits(registry, RateLimits)
+	if AccessLog != nil {
+		metrics.RegisterAccessLog(registry, AccessLog)
+	}
+
+	trustedProxies, err := middlewares.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		fatal("parsing trusted proxies failed", err)
+	}
 
 	router := chi.NewRouter()
 
-	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(metrics.NewHTTPMetrics(registry).Middleware)
+	router.Use(tracing.Middleware)
+	router.Use(middlewares.RealIP(trustedProxies))
+	router.Use(middlewares.RequestID)
+	router.Use(middlewares.RequestLogger)
 	router.Use(middleware.CleanPath)
-	router.Use(middlewares.FileLoggingMiddleware)
+	if AccessLog != nil {
+		router.Use(AccessLog.Middleware)
+	}
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", middlewares.RequestIDHeader},
+		ExposedHeaders:   []string{"Link", "Retry-After", middlewares.RequestIDHeader},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
 	router.Use(middleware.Recoverer)
+	router.NotFound(customerrors.NotFoundHandler)
+	router.MethodNotAllowed(customerrors.MethodNotAllowedHandler)
 
-	router.Mount("/api", routes.SetupRoutes(DB, CreateURLRateLimit, GetURLRateLimit))
+	health := handlers.NewHealth(DB, Version)
+	routes.HealthRoutes(router, health)
+	router.Mount("/api", routes.SetupRoutes(DB, Importer, AdminToken, RateLimits))
 
 	server := &http.Server{
-		Addr:         ":8081",
+		Addr:         cfg.Server.Addr,
 		Handler:      router,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server running", "addr", cfg.Server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	var admin *http.Server
+	if cfg.Admin.Addr != "" {
+		adminRouter := chi.NewRouter()
+		adminRouter.Handle("/metrics", metrics.Handler(registry))
+		admin = &http.Server{
+			Addr:         cfg.Admin.Addr,
+			Handler:      adminRouter,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+		}
+		go func() {
+			slog.Info("admin server running", "addr", cfg.Admin.Addr)
+			if err := admin.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("admin server failed", "err", err)
+			}
+		}()
+	}
+
+	signalled := false
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server failed", "err", err)
+		}
+	case <-ctx.Done():
+		slog.Info("shutdown signal received, draining")
+		signalled = true
+	}
+	// A second signal kills the process instead of waiting for the drain.
+	stop()
+
+	if signalled {
+		// Keep serving while /readyz fails, so the load balancer moves
+		// traffic away before connections are refused.
+		health.SetDraining()
+		time.Sleep(cfg.Server.DrainDelay)
+	}
+
+	shutdown(server, admin, cfg.Server.ShutdownTimeout)
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := stopTracing(flushCtx); err != nil {
+		slog.Error("flushing traces failed", "err", err)
 	}
+}
 
-	log.Println("Server running on http://localhost:8081")
-	server.ListenAndServe()
+// shutdown stops taking connections, waits for in-flight requests and then
+// drains the insert and import queues, all within timeout. The insert queue
+// goes first because its links were already acknowledged; imports still
+// running when time is up are interrupted and marked failed. The access log
+// gets a few seconds of its own afterwards, so a long import can't cost it
+// its entries.
+// The admin server stays up until the end so the drain can be watched.
+func shutdown(server, admin *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("shutdown: closing connections failed", "err", err)
+	}
+
+	report := DB.DrainInserts(ctx)
+	slog.Info("shutdown: insert queue drained",
+		"pending", report.Pending, "flushed", report.Flushed, "failed", report.Failed, "dropped", report.Dropped)
+
+	Importer.Close(ctx)
+
+	RateLimits.Close()
+
+	// The insert queue is empty by now; this only closes connections.
+	if _, err := DB.Shutdown(context.Background()); err != nil {
+		slog.Error("shutdown: closing storage failed", "err", err)
+	}
+
+	if AccessLog != nil {
+		logCtx, cancel := context.WithTimeout(context.Background(), accessLogDrainTimeout)
+		defer cancel()
+		logs := AccessLog.Close(logCtx)
+		slog.Info("shutdown: access log drained", "flushed", logs.Flushed, "dropped", logs.Dropped)
+	}
+
+	if admin != nil {
+		admin.Close()
+	}
 }
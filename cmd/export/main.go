@@ -0,0 +1,69 @@
+// Command export dumps all links, optionally with click counts, to a file or
+// stdout for loading into the warehouse. It reads the backend's
+// configuration, so the same config file, environment and flags apply.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/config"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/export"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func main() {
+	format := flag.String("format", export.FormatCSV, "output format: csv, ndjson or parquet")
+	output := flag.String("o", "-", "output file, - for stdout")
+	withClicks := flag.Bool("stats", false, "include aggregated click counts")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// run cleans up after itself, which log.Fatal would skip.
+	if err := run(cfg, *format, *output, *withClicks); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg *config.Config, format, output string, withClicks bool) (err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.Postgres.DSN())
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	var out io.Writer = os.Stdout
+	if output != "-" {
+		var file *os.File
+		file, err = os.Create(output)
+		if err != nil {
+			return err
+		}
+		// A partial export would be mistaken for a complete one.
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(output)
+			}
+		}()
+		out = file
+	}
+
+	DB := &Storage.URLDB{DB: pool}
+	return handlers.ExportLinks(ctx, DB, out, format, withClicks)
+}
@@ -1 +1,313 @@
+// Package config loads the backend's settings. Each setting can come from
+// four places; later ones win:
+//
+//  1. the defaults below
+//  2. a YAML file named by -config or CONFIG_FILE
+//  3. the environment variable in the field's env tag; NAME_FILE instead of
+//     NAME reads the value from a file, for Docker secrets
+//  4. a command-line flag named after the field's YAML path, e.g.
+//     -postgres.host
+//
+// Fields tagged reload:"true" can also change while running; see Watcher.
 package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/tracing"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+)
+
+type Config struct {
+	Server     ServerConfig         `yaml:"server"`
+	Postgres   PostgresConfig       `yaml:"postgres"`
+	Redis      RedisConfig          `yaml:"redis"`
+	Cache      CacheConfig          `yaml:"cache"`
+	Storage    StorageConfig        `yaml:"storage"`
+	Imports    ImportConfig         `yaml:"imports"`
+	ShortCodes ShortCodeConfig      `yaml:"short_codes"`
+	Admin      AdminConfig          `yaml:"admin"`
+	Log        LogConfig            `yaml:"log"`
+	AccessLog  AccessLogConfig      `yaml:"access_log"`
+	Tracing    TracingConfig        `yaml:"tracing"`
+	RateLimits []middlewares.Policy `yaml:"rate_limits" reload:"true"`
+	// BlockedDomains are refused as link targets, along with their
+	// subdomains.
+	BlockedDomains []string `yaml:"blocked_domains" env:"BLOCKED_DOMAINS" reload:"true"`
+}
+
+type ServerConfig struct {
+	Addr         string        `yaml:"addr" env:"SERVER_ADDR"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// ShutdownTimeout bounds how long a stopping server waits for requests
+	// and queues to drain.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+	// DrainDelay is how long /readyz fails before the server stops taking
+	// connections, giving the load balancer time to notice.
+	DrainDelay time.Duration `yaml:"drain_delay" env:"SERVER_DRAIN_DELAY"`
+	// TrustedProxies are the addresses or CIDR ranges whose X-Forwarded-For
+	// and X-Real-IP headers are believed. With none, clients are always
+	// identified by the connection's address.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"SERVER_TRUSTED_PROXIES"`
+}
+
+type PostgresConfig struct {
+	Host     string `yaml:"host" env:"POSTGRES_HOST"`
+	Port     int    `yaml:"port" env:"POSTGRES_PORT"`
+	User     string `yaml:"user" env:"POSTGRES_USER"`
+	Password string `yaml:"password" env:"POSTGRES_PASSWORD" secret:"true"`
+	Database string `yaml:"database" env:"POSTGRES_DB"`
+	SSLMode  string `yaml:"sslmode" env:"POSTGRES_SSLMODE"`
+}
+
+type RedisConfig struct {
+	Host string `yaml:"host" env:"REDIS_HOST"`
+	Port int    `yaml:"port" env:"REDIS_PORT"`
+}
+
+type CacheConfig struct {
+	MaxEntries  int           `yaml:"max_entries" env:"CACHE_MAX_ENTRIES"`
+	MaxBytes    int64         `yaml:"max_bytes" env:"CACHE_MAX_BYTES"`
+	LocalTTL    time.Duration `yaml:"local_ttl" env:"CACHE_LOCAL_TTL" reload:"true"`
+	FallbackTTL time.Duration `yaml:"fallback_ttl" env:"CACHE_FALLBACK_TTL" reload:"true"`
+	NegativeTTL time.Duration `yaml:"negative_ttl" env:"CACHE_NEGATIVE_TTL" reload:"true"`
+	RedisTTL    time.Duration `yaml:"redis_ttl" env:"CACHE_REDIS_TTL" reload:"true"`
+}
+
+type StorageConfig struct {
+	InsertWorkers   int `yaml:"insert_workers" env:"INSERT_WORKERS"`
+	InsertQueueSize int `yaml:"insert_queue_size" env:"INSERT_QUEUE_SIZE"`
+}
+
+type ImportConfig struct {
+	Workers   int `yaml:"workers" env:"IMPORT_WORKERS"`
+	QueueSize int `yaml:"queue_size" env:"IMPORT_QUEUE_SIZE"`
+}
+
+type ShortCodeConfig struct {
+	// Alphabet is empty for the default alphabet, "safe" for one without
+	// look-alike characters, or the literal characters to use.
+	Alphabet string `yaml:"alphabet" env:"SHORT_CODE_ALPHABET"`
+	Length   int    `yaml:"length" env:"SHORT_CODE_LENGTH"`
+	Salt     string `yaml:"salt" env:"SHORT_CODE_SALT" secret:"true"`
+	Strategy string `yaml:"strategy" env:"SHORT_CODE_STRATEGY"`
+}
+
+type AdminConfig struct {
+	// Token guards the /api/admin endpoints; they are disabled without one.
+	Token string `yaml:"token" env:"ADMIN_TOKEN" secret:"true"`
+	// Addr is where /metrics is served, apart from the public API so it
+	// can be firewalled off. Empty disables it.
+	Addr string `yaml:"addr" env:"ADMIN_ADDR"`
+}
+
+type LogConfig struct {
+	// Format is json or text.
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" reload:"true"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, otlp or stdout.
+	Exporter    string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	ServiceName string `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+	// Endpoint is the collector's OTLP/HTTP host:port.
+	Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	// Insecure sends spans over plain HTTP, as a local collector expects.
+	Insecure    bool    `yaml:"insecure" env:"TRACING_INSECURE"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+type AccessLogConfig struct {
+	// Path is the file to write; empty disables the access log.
+	Path string `yaml:"path" env:"ACCESS_LOG_PATH"`
+	// Format is common, combined or json.
+	Format     string `yaml:"format" env:"ACCESS_LOG_FORMAT"`
+	BufferSize int    `yaml:"buffer_size" env:"ACCESS_LOG_BUFFER_SIZE"`
+	// OnFull is drop or block.
+	OnFull      string        `yaml:"on_full" env:"ACCESS_LOG_ON_FULL"`
+	MaxSize     int64         `yaml:"max_size" env:"ACCESS_LOG_MAX_SIZE"`
+	RotateEvery time.Duration `yaml:"rotate_every" env:"ACCESS_LOG_ROTATE_EVERY"`
+	MaxBackups  int           `yaml:"max_backups" env:"ACCESS_LOG_MAX_BACKUPS"`
+	Compress    bool          `yaml:"compress" env:"ACCESS_LOG_COMPRESS"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8081",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 25 * time.Second,
+			DrainDelay:      5 * time.Second,
+		},
+		Postgres: PostgresConfig{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "disable",
+		},
+		Redis: RedisConfig{
+			Host: "localhost",
+			Port: 6379,
+		},
+		Cache: CacheConfig{
+			MaxEntries:  100000,
+			MaxBytes:    64 << 20,
+			LocalTTL:    5 * time.Minute,
+			FallbackTTL: 5 * time.Second,
+			NegativeTTL: 30 * time.Second,
+			RedisTTL:    24 * time.Hour,
+		},
+		Storage: StorageConfig{
+			InsertWorkers:   5,
+			InsertQueueSize: 200,
+		},
+		Imports: ImportConfig{
+			Workers:   2,
+			QueueSize: 16,
+		},
+		ShortCodes: ShortCodeConfig{
+			Length: 7,
+		},
+		Admin: AdminConfig{
+			Addr: ":9090",
+		},
+		Log: LogConfig{
+			Format: utils.LogFormatJSON,
+			Level:  "info",
+		},
+		AccessLog: AccessLogConfig{
+			Path:        "Stream.log",
+			Format:      middlewares.AccessLogCombined,
+			BufferSize:  1000,
+			OnFull:      middlewares.AccessLogDrop,
+			MaxSize:     100 << 20,
+			RotateEvery: 24 * time.Hour,
+			MaxBackups:  7,
+			Compress:    true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    tracing.ExporterNone,
+			ServiceName: "url-shortner",
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: 1,
+		},
+		RateLimits: append([]middlewares.Policy(nil), middlewares.DefaultPolicies...),
+	}
+}
+
+// DSN is the Postgres connection URL.
+func (p PostgresConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(p.User, p.Password),
+		Host:     fmt.Sprintf("%s:%d", p.Host, p.Port),
+		Path:     "/" + p.Database,
+		RawQuery: url.Values{"sslmode": {p.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+func (r RedisConfig) Addr() string {
+	return fmt.Sprintf("%s:%d", r.Host, r.Port)
+}
+
+var sslModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true,
+	"require": true, "verify-ca": true, "verify-full": true,
+}
+
+// Validate reports every problem at once, each naming the setting by its
+// YAML path.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay can't be negative")
+	_, err := middlewares.ParseTrustedProxies(c.Server.TrustedProxies)
+	check(err == nil, "server.trusted_proxies: %v", err)
+
+	check(c.Postgres.Host != "", "postgres.host is required")
+	check(validPort(c.Postgres.Port), "postgres.port must be between 1 and 65535, got %d", c.Postgres.Port)
+	check(c.Postgres.User != "", "postgres.user is required (POSTGRES_USER)")
+	check(c.Postgres.Database != "", "postgres.database is required (POSTGRES_DB)")
+	check(sslModes[c.Postgres.SSLMode], "postgres.sslmode %q is not a libpq sslmode", c.Postgres.SSLMode)
+
+	check(c.Redis.Host != "", "redis.host is required")
+	check(validPort(c.Redis.Port), "redis.port must be between 1 and 65535, got %d", c.Redis.Port)
+
+	check(c.Cache.MaxEntries >= 0, "cache.max_entries can't be negative")
+	check(c.Cache.MaxBytes >= 0, "cache.max_bytes can't be negative")
+	check(c.Cache.LocalTTL > 0, "cache.local_ttl must be positive")
+	check(c.Cache.FallbackTTL > 0, "cache.fallback_ttl must be positive")
+	check(c.Cache.FallbackTTL <= c.Cache.LocalTTL, "cache.fallback_ttl can't be longer than cache.local_ttl")
+	check(c.Cache.NegativeTTL > 0, "cache.negative_ttl must be positive")
+	check(c.Cache.RedisTTL > 0, "cache.redis_ttl must be positive")
+
+	check(c.Storage.InsertWorkers > 0, "storage.insert_workers must be at least 1")
+	check(c.Storage.InsertQueueSize > 0, "storage.insert_queue_size must be at least 1")
+	check(c.Imports.Workers > 0, "imports.workers must be at least 1")
+	check(c.Imports.QueueSize > 0, "imports.queue_size must be at least 1")
+
+	check(c.ShortCodes.Length >= 1 && c.ShortCodes.Length <= 10,
+		"short_codes.length must be between 1 and 10, got %d", c.ShortCodes.Length)
+
+	check(c.Log.Format == utils.LogFormatJSON || c.Log.Format == utils.LogFormatText,
+		"log.format must be json or text, got %q", c.Log.Format)
+	_, err = utils.ParseLogLevel(c.Log.Level)
+	check(err == nil, "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+
+	switch c.AccessLog.Format {
+	case middlewares.AccessLogCommon, middlewares.AccessLogCombined, middlewares.AccessLogJSON:
+	default:
+		errs = append(errs, fmt.Errorf("access_log.format must be common, combined or json, got %q", c.AccessLog.Format))
+	}
+	check(c.AccessLog.OnFull == middlewares.AccessLogDrop || c.AccessLog.OnFull == middlewares.AccessLogBlock,
+		"access_log.on_full must be drop or block, got %q", c.AccessLog.OnFull)
+	check(c.AccessLog.BufferSize > 0, "access_log.buffer_size must be at least 1")
+	check(c.AccessLog.MaxSize >= 0, "access_log.max_size can't be negative")
+	check(c.AccessLog.RotateEvery >= 0, "access_log.rotate_every can't be negative")
+	check(c.AccessLog.MaxBackups >= 0, "access_log.max_backups can't be negative")
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be none, otlp or stdout, got %q", c.Tracing.Exporter))
+	}
+	check(c.Tracing.Exporter != tracing.ExporterOTLP || c.Tracing.Endpoint != "",
+		"tracing.endpoint is required for the otlp exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+		"tracing.sample_ratio must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+
+	for _, domain := range c.BlockedDomains {
+		check(!strings.ContainsAny(domain, "/: "), "blocked_domains: %q is not a domain name", domain)
+	}
+
+	if err := middlewares.ValidatePolicies(c.RateLimits); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limits: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port < 65536
+}
@@ -1 +1,239 @@
 package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the flags in args, then validates it. Its flags are added
+// to fs, which may already define the caller's own; fs is parsed here.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	loader, err := NewLoader(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	return loader.Load()
+}
+
+// Loader remembers the parsed flags so the configuration can be built again
+// when the file or environment changes.
+type Loader struct {
+	fs         *flag.FlagSet
+	configFile string
+	// flags maps each flag to the index path of its field in Config.
+	flags map[string][]int
+}
+
+// NewLoader adds the config flags to fs and parses args.
+func NewLoader(fs *flag.FlagSet, args []string) (*Loader, error) {
+	configFile := fs.String("config", "", "path to a YAML config file (env CONFIG_FILE)")
+	flags := registerFlags(fs, reflect.TypeOf(Config{}), "", nil)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return &Loader{fs: fs, configFile: *configFile, flags: flags}, nil
+}
+
+// Path is the config file in use, or "" if there is none.
+func (l *Loader) Path() string {
+	if l.configFile != "" {
+		return l.configFile
+	}
+	return os.Getenv("CONFIG_FILE")
+}
+
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+	if path := l.Path(); path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), ""); err != nil {
+		return nil, err
+	}
+
+	var flagErr error
+	root := reflect.ValueOf(cfg).Elem()
+	l.fs.Visit(func(f *flag.Flag) {
+		index, ok := l.flags[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		if err := setValue(root.FieldByIndex(index), f.Value.String()); err != nil {
+			flagErr = fmt.Errorf("config: -%s: %w", f.Name, err)
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// loadFile decodes path over cfg. Unknown keys are errors, so a typo doesn't
+// silently leave a default in place.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// registerFlags adds a string flag for every scalar field, named by its YAML
+// path, and returns the fields' index paths by flag name. Values are parsed
+// after fs.Parse so that only flags actually given override anything.
+func registerFlags(fs *flag.FlagSet, t reflect.Type, prefix string, index []int) map[string][]int {
+	fields := make(map[string][]int)
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		name := prefix + yamlName(sf)
+		path := append(append([]int(nil), index...), i)
+		switch {
+		case sf.Type.Kind() == reflect.Struct:
+			for k, f := range registerFlags(fs, sf.Type, name+".", path) {
+				fields[k] = f
+			}
+		case settable(sf.Type):
+			usage := "sets " + name
+			if env := sf.Tag.Get("env"); env != "" {
+				usage += fmt.Sprintf(" (env %s)", env)
+			}
+			fs.String(name, "", usage)
+			fields[name] = path
+		}
+	}
+	return fields
+}
+
+// applyEnv sets every field with an env tag from NAME, or from the file
+// named by NAME_FILE.
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		field := v.Field(i)
+		if sf.Type.Kind() == reflect.Struct {
+			if err := applyEnv(field, prefix+yamlName(sf)+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		env := sf.Tag.Get("env")
+		if env == "" {
+			continue
+		}
+		raw, source, ok, err := lookupEnv(env)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", prefix+yamlName(sf), err)
+		}
+		if !ok {
+			continue
+		}
+		if err := setValue(field, raw); err != nil {
+			shown := raw
+			if sf.Tag.Get("secret") == "true" {
+				shown = "<redacted>"
+			}
+			return fmt.Errorf("config: %s: invalid value %q from %s: %w", prefix+yamlName(sf), shown, source, err)
+		}
+	}
+	return nil
+}
+
+func lookupEnv(name string) (value, source string, ok bool, err error) {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", false, fmt.Errorf("reading %s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), name + "_FILE", true, nil
+	}
+	value, ok = os.LookupEnv(name)
+	return value, name, ok, nil
+}
+
+func yamlName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(sf.Name)
+	}
+	return name
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Bool, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func setValue(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("not a whole number")
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("not true or false")
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// reloadPollInterval is how often the config file is checked for changes.
+const reloadPollInterval = 5 * time.Second
+
+// Watcher holds the running configuration and reloads it on SIGHUP or when
+// the config file changes. Only fields tagged reload:"true" take effect;
+// changes to the rest are logged as needing a restart. A configuration that
+// fails to load or validate is rejected and the running one kept.
+type Watcher struct {
+	loader   *Loader
+	current  atomic.Pointer[Config]
+	mu       sync.Mutex
+	onReload []func(*Config)
+	modTime  time.Time
+}
+
+func NewWatcher(loader *Loader, cfg *Config) *Watcher {
+	w := &Watcher{loader: loader}
+	w.current.Store(cfg)
+	w.modTime = w.fileModTime()
+	return w
+}
+
+// Current is the configuration in effect. The returned value must not be
+// modified; a reload replaces it rather than changing it.
+func (w *Watcher) Current() *Config {
+	return w.current.Load()
+}
+
+// OnReload registers fn to apply a new configuration. Callbacks run in
+// registration order, after the new configuration is visible to Current.
+func (w *Watcher) OnReload(fn func(cfg *Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onReload = append(w.onReload, fn)
+}
+
+// Reload loads the configuration again and applies the reloadable changes.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.modTime = w.fileModTime()
+	next, err := w.loader.Load()
+	if err != nil {
+		slog.Error("config reload rejected, keeping the running configuration", "err", err)
+		return err
+	}
+
+	old := w.current.Load()
+	merged := *old
+	var changed, restart []string
+	mergeReloadable(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(next).Elem(), "", &changed, &restart)
+	if len(restart) > 0 {
+		slog.Warn("config reload: settings only take effect after a restart", "settings", restart)
+	}
+	if len(changed) == 0 {
+		slog.Info("config reload: nothing to apply")
+		return nil
+	}
+
+	w.current.Store(&merged)
+	for _, fn := range w.onReload {
+		fn(&merged)
+	}
+	slog.Info("config reloaded", "changes", changed)
+	return nil
+}
+
+// Run reloads on SIGHUP and when the config file's modification time
+// changes, until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(reloadPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("config reload: SIGHUP received")
+			w.Reload()
+		case <-ticker.C:
+			if w.fileChanged() {
+				slog.Info("config reload: file changed", "path", w.loader.Path())
+				w.Reload()
+			}
+		}
+	}
+}
+
+func (w *Watcher) fileChanged() bool {
+	modTime := w.fileModTime()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !modTime.Equal(w.modTime)
+}
+
+func (w *Watcher) fileModTime() time.Time {
+	path := w.loader.Path()
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// mergeReloadable copies the reloadable fields of next into cur, describing
+// each change, and lists the other fields that differ.
+func mergeReloadable(cur, next reflect.Value, prefix string, changed, restart *[]string) {
+	t := cur.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		name := prefix + yamlName(sf)
+		a, b := cur.Field(i), next.Field(i)
+		if sf.Type.Kind() == reflect.Struct {
+			mergeReloadable(a, b, name+".", changed, restart)
+			continue
+		}
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			continue
+		}
+		if sf.Tag.Get("reload") != "true" {
+			*restart = append(*restart, name)
+			continue
+		}
+		*changed = append(*changed, fmt.Sprintf("%s: %v -> %v", name, a.Interface(), b.Interface()))
+		a.Set(b)
+	}
+}
@@ -1,8 +1,110 @@
+// Package customerrors defines the errors handlers return to clients. Each
+// has a Kind, which decides the HTTP status, and a message safe to show the
+// caller; the cause, if any, is only logged. Write turns them into RFC 9457
+// problem details.
 package customerrors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Kind says what went wrong in terms a client can act on.
+type Kind string
 
-var (
-	ErrAlreadyShortened = errors.New("this URL is already shortened")
-	ErrInvalidLongURL   = errors.New("Invalid long url")
+const (
+	KindInternal        Kind = "internal"
+	KindInvalid         Kind = "invalid-request"
+	KindInvalidURL      Kind = "invalid-url"
+	KindUnsafeHost      Kind = "unsafe-host"
+	KindNotFound        Kind = "not-found"
+	KindMethod          Kind = "method-not-allowed"
+	KindGone            Kind = "gone"
+	KindConflict        Kind = "conflict"
+	KindUnauthorized    Kind = "unauthorized"
+	KindTooLarge        Kind = "too-large"
+	KindUpgradeRequired Kind = "upgrade-required"
+	KindQuotaExceeded   Kind = "quota-exceeded"
+	KindRateLimited     Kind = "rate-limited"
+	KindUnavailable     Kind = "unavailable"
 )
+
+type Error struct {
+	Kind Kind
+	// Message is shown to the client as the problem's detail.
+	Message string
+	// Err is the cause. It is logged, never sent.
+	Err error
+	// RetryAfter, if set, is sent as the Retry-After header.
+	RetryAfter time.Duration
+	// Fields are added to the problem as extension members.
+	Fields map[string]any
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+func Newf(kind Kind, format string, args ...any) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func NotFound(message string) *Error    { return New(KindNotFound, message) }
+func Gone(message string) *Error        { return New(KindGone, message) }
+func InvalidURL(message string) *Error  { return New(KindInvalidURL, message) }
+func UnsafeHost(message string) *Error  { return New(KindUnsafeHost, message) }
+func Conflict(message string) *Error    { return New(KindConflict, message) }
+func Unavailable(message string) *Error { return New(KindUnavailable, message) }
+
+func Invalidf(format string, args ...any) *Error {
+	return Newf(KindInvalid, format, args...)
+}
+
+// Internal wraps an unexpected err with a message for the client. An err
+// that already has a kind is returned as is, so wrapping never hides a
+// typed error from further down.
+func Internal(err error, message string) error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return err
+	}
+	return &Error{Kind: KindInternal, Message: message, Err: err}
+}
+
+// InvalidRequest reports err from reading a request body. Like Internal it
+// returns an err that already has a kind as is; a body over the size limit is
+// KindTooLarge and anything else is KindInvalid.
+func InvalidRequest(err error) error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return err
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &Error{Kind: KindTooLarge, Message: fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit), Err: err}
+	}
+	return &Error{Kind: KindInvalid, Message: fmt.Sprintf("invalid request: %v", err), Err: err}
+}
+
+// KindOf returns the kind of the first *Error in err's chain, or
+// KindInternal if there is none.
+func KindOf(err error) Kind {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed.Kind
+	}
+	return KindInternal
+}
@@ -0,0 +1,139 @@
+package customerrors
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ProblemContentType is the media type of RFC 9457 problem details.
+const ProblemContentType = "application/problem+json"
+
+// typeBase prefixes each kind to form the problem's type URI. It names the
+// kind; it isn't meant to be fetched.
+const typeBase = "urn:url-shortner:problem:"
+
+var statuses = map[Kind]int{
+	KindInternal:        http.StatusInternalServerError,
+	KindInvalid:         http.StatusBadRequest,
+	KindInvalidURL:      http.StatusUnprocessableEntity,
+	KindUnsafeHost:      http.StatusUnprocessableEntity,
+	KindNotFound:        http.StatusNotFound,
+	KindMethod:          http.StatusMethodNotAllowed,
+	KindGone:            http.StatusGone,
+	KindConflict:        http.StatusConflict,
+	KindUnauthorized:    http.StatusUnauthorized,
+	KindTooLarge:        http.StatusRequestEntityTooLarge,
+	KindUpgradeRequired: http.StatusPaymentRequired,
+	KindQuotaExceeded:   http.StatusTooManyRequests,
+	KindRateLimited:     http.StatusTooManyRequests,
+	KindUnavailable:     http.StatusServiceUnavailable,
+}
+
+var titles = map[Kind]string{
+	KindInternal:        "Internal error",
+	KindInvalid:         "Invalid request",
+	KindInvalidURL:      "Invalid URL",
+	KindUnsafeHost:      "Unsafe destination",
+	KindNotFound:        "Not found",
+	KindMethod:          "Method not allowed",
+	KindGone:            "Gone",
+	KindConflict:        "Conflict",
+	KindUnauthorized:    "Unauthorized",
+	KindTooLarge:        "Request too large",
+	KindUpgradeRequired: "Plan limit reached",
+	KindQuotaExceeded:   "Quota exceeded",
+	KindRateLimited:     "Too many requests",
+	KindUnavailable:     "Service unavailable",
+}
+
+// Status is the HTTP status for kind.
+func Status(kind Kind) int {
+	if status, ok := statuses[kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Problem is an RFC 9457 problem details object. RequestID and Fields are
+// extension members.
+type Problem struct {
+	Type      string
+	Title     string
+	Status    int
+	Detail    string
+	Instance  string
+	RequestID string
+	Fields    map[string]any
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Fields)+6)
+	for k, v := range p.Fields {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	m["title"] = p.Title
+	m["status"] = p.Status
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	if p.RequestID != "" {
+		m["request_id"] = p.RequestID
+	}
+	return json.Marshal(m)
+}
+
+// ProblemFor describes err as returned to the client for r. Errors without a
+// kind are internal and get a generic detail, so their text never leaks.
+func ProblemFor(r *http.Request, err error) Problem {
+	var typed *Error
+	if !errors.As(err, &typed) {
+		typed = &Error{Kind: KindInternal, Message: "Something went wrong", Err: err}
+	}
+	status := Status(typed.Kind)
+	return Problem{
+		Type:      typeBase + string(typed.Kind),
+		Title:     titles[typed.Kind],
+		Status:    status,
+		Detail:    typed.Message,
+		Instance:  r.URL.Path,
+		RequestID: middleware.GetReqID(r.Context()),
+		Fields:    typed.Fields,
+	}
+}
+
+// Write sends err as problem details. Server-side failures are logged with
+// their cause; the client only sees the request ID to quote.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	problem := ProblemFor(r, err)
+	if problem.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "request failed", "status", problem.Status, "err", err)
+	}
+	var typed *Error
+	if errors.As(err, &typed) && typed.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(typed.RetryAfter.Seconds()))))
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// NotFoundHandler and MethodNotAllowedHandler answer requests the router
+// has no route for.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, NotFound("No such endpoint"))
+}
+
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, New(KindMethod, r.Method+" is not allowed here"))
+}
@@ -0,0 +1,138 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+
+	parquetBufferRows = 1000
+	parquetRowGroup   = 100000
+)
+
+// Writer encodes exported links one at a time. Close must be called to flush
+// buffered rows and, for Parquet, write the footer.
+type Writer interface {
+	Write(row Storage.ExportRow) error
+	Close() error
+}
+
+func NewWriter(format string, w io.Writer, withClicks bool) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, withClicks)
+	case FormatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return &parquetWriter{
+			w: parquet.NewGenericWriter[Storage.ExportRow](w, parquet.MaxRowsPerRowGroup(parquetRowGroup)),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+type csvWriter struct {
+	w          *csv.Writer
+	withClicks bool
+}
+
+func newCSVWriter(w io.Writer, withClicks bool) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), withClicks: withClicks}
+	header := []string{"code", "destination", "owner_id", "created_at", "expires_at", "expired"}
+	if withClicks {
+		header = append(header, "clicks")
+	}
+	return cw, cw.w.Write(header)
+}
+
+func (cw *csvWriter) Write(row Storage.ExportRow) error {
+	record := []string{
+		row.Code,
+		row.Destination,
+		"",
+		row.CreatedAt.UTC().Format(time.RFC3339),
+		"",
+		strconv.FormatBool(row.Expired),
+	}
+	if row.OwnerID != nil {
+		record[2] = strconv.FormatInt(*row.OwnerID, 10)
+	}
+	if row.ExpiresAt != nil {
+		record[4] = row.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if cw.withClicks {
+		clicks := int64(0)
+		if row.Clicks != nil {
+			clicks = *row.Clicks
+		}
+		record = append(record, strconv.FormatInt(clicks, 10))
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonWriter) Write(row Storage.ExportRow) error {
+	return nw.enc.Encode(row)
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	w   *parquet.GenericWriter[Storage.ExportRow]
+	buf []Storage.ExportRow
+}
+
+func (pw *parquetWriter) Write(row Storage.ExportRow) error {
+	pw.buf = append(pw.buf, row)
+	if len(pw.buf) < parquetBufferRows {
+		return nil
+	}
+	return pw.flush()
+}
+
+func (pw *parquetWriter) flush() error {
+	if len(pw.buf) == 0 {
+		return nil
+	}
+	_, err := pw.w.Write(pw.buf)
+	pw.buf = pw.buf[:0]
+	return err
+}
+
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	return pw.w.Close()
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"context"
+
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+const (
+	DefaultHotKeyLimit = 100
+	MaxHotKeyLimit     = 1000
+)
+
+// HotKeys lists the most requested codes across all replicas.
+func HotKeys(ctx context.Context, DB *Storage.URLDB, limit int) ([]Storage.HotKey, error) {
+	if limit <= 0 {
+		limit = DefaultHotKeyLimit
+	}
+	return DB.HotKeys(ctx, min(limit, MaxHotKeyLimit))
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+)
+
+const (
+	MaxBulkItems      = 10000
+	bulkBatchSize     = 500
+	bulkValidators    = 16
+	maxTagsPerLink    = 20
+	maxTagLength      = 64
+	bulkInsertTimeout = 30 * time.Second
+)
+
+var aliasRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedAliases are path segments the API itself serves under /api, so a
+// link with one of these codes could never be reached.
+var reservedAliases = map[string]bool{
+	"admin":   true,
+	"auth":    true,
+	"create":  true,
+	"export":  true,
+	"folders": true,
+	"imports": true,
+	"jobs":    true,
+	"links":   true,
+	"me":      true,
+	"tags":    true,
+}
+
+type BulkItem struct {
+	LongURL   string     `json:"long_url"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	// Invalid is set by request parsers for rows they couldn't decode, so the
+	// row is reported instead of failing the whole request.
+	Invalid string `json:"-"`
+}
+
+type BulkResult struct {
+	Index   int    `json:"index"`
+	LongURL string `json:"long_url"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Conflict is set when the requested alias was already taken.
+	Conflict bool `json:"conflict,omitempty"`
+}
+
+// CreateShortURLsBulk shortens every item independently: a bad row gets an
+// error in its result and never fails the rest of the request. Codes for items
+// without an alias come from opts.Strategy; opts.Tags is ignored in favour of
+// each item's own tags.
+func CreateShortURLsBulk(ctx context.Context, DB *Storage.URLDB, items []BulkItem, opts CreateOptions) ([]BulkResult, error) {
+	if len(items) > MaxBulkItems {
+		return nil, customerrors.Newf(customerrors.KindTooLarge, "too many items: %d, the limit is %d", len(items), MaxBulkItems)
+	}
+	gen, err := GeneratorByName(opts.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	return createBulk(ctx, DB, gen, items, opts.OwnerID, false), nil
+}
+
+// createBulk validates and inserts items. With fallback set, an item whose
+// alias is taken gets a generated code instead of an error.
+func createBulk(ctx context.Context, DB *Storage.URLDB, gen CodeGenerator, items []BulkItem, owner *int64, fallback bool) []BulkResult {
+	results := make([]BulkResult, len(items))
+	for i, item := range items {
+		results[i] = BulkResult{Index: i, LongURL: item.LongURL}
+	}
+	validateBulkItems(items, results)
+
+	pending := make([]int, 0, len(items))
+	for i := range items {
+		if results[i].Error == "" {
+			pending = append(pending, i)
+		}
+	}
+	for start := 0; start < len(pending); start += bulkBatchSize {
+		end := min(start+bulkBatchSize, len(pending))
+		insertBulkBatch(ctx, DB, gen, items, results, pending[start:end], owner, fallback)
+	}
+	return results
+}
+
+func validateBulkItems(items []BulkItem, results []BulkResult) {
+	aliases := make(map[string]int)
+	for i := range items {
+		item := &items[i]
+		if item.Invalid != "" {
+			results[i].Error = item.Invalid
+			continue
+		}
+		item.LongURL = strings.TrimSpace(item.LongURL)
+		item.Alias = strings.TrimSpace(item.Alias)
+		tags, err := NormalizeTags(item.Tags)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		item.Tags = tags
+		if item.LongURL == "" {
+			results[i].Error = "missing long_url"
+			continue
+		}
+		if item.Alias != "" {
+			if err := ValidateAlias(item.Alias); err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			if first, dup := aliases[item.Alias]; dup {
+				results[i].Error = fmt.Sprintf("alias %q is already used by item %d", item.Alias, first)
+				continue
+			}
+			aliases[item.Alias] = i
+		}
+		if item.ExpiresAt != nil && !item.ExpiresAt.After(time.Now()) {
+			results[i].Error = "expires_at is in the past"
+		}
+	}
+
+	// ValidateURL resolves the host, so run the lookups concurrently.
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range bulkValidators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := utils.ValidateURL(items[i].LongURL); err != nil {
+					results[i].Error = err.Error()
+				}
+			}
+		}()
+	}
+	for i := range items {
+		if results[i].Error == "" {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func insertBulkBatch(ctx context.Context, DB *Storage.URLDB, gen CodeGenerator, items []BulkItem, results []BulkResult, batch []int, owner *int64, fallback bool) {
+	const maxGenerateAttmept = 10
+	// The batch is finished even if the caller goes away; only its log
+	// fields are kept.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bulkInsertTimeout)
+	defer cancel()
+
+	for attempt := 0; attempt < maxGenerateAttmept && len(batch) > 0; attempt++ {
+		rows := make([]Storage.NewURL, 0, len(batch))
+		for _, i := range batch {
+			code := items[i].Alias
+			if code == "" {
+				var err error
+				code, err = gen.Generate(items[i].LongURL, attempt)
+				if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+			}
+			results[i].Code = code
+			rows = append(rows, Storage.NewURL{
+				Short:     code,
+				Long:      items[i].LongURL,
+				ExpiresAt: items[i].ExpiresAt,
+				Tags:      items[i].Tags,
+				OwnerID:   owner,
+			})
+		}
+
+		if len(rows) == 0 {
+			return
+		}
+		inserted, err := DB.SaveURLsBatch(ctx, rows)
+		if err != nil {
+			for _, i := range batch {
+				results[i].Code = ""
+				if results[i].Error == "" {
+					results[i].Error = "failed to store link"
+				}
+			}
+			slog.ErrorContext(ctx, "bulk create: storing links failed", "err", err)
+			return
+		}
+
+		var retry []int
+		row := 0
+		for _, i := range batch {
+			if results[i].Error != "" {
+				continue
+			}
+			ok := inserted[row]
+			row++
+			if ok {
+				continue
+			}
+			results[i].Code = ""
+			if items[i].Alias != "" {
+				results[i].Conflict = true
+				if !fallback {
+					results[i].Error = fmt.Sprintf("alias %q is already taken", items[i].Alias)
+					continue
+				}
+				items[i].Alias = ""
+			}
+			retry = append(retry, i)
+		}
+		batch = retry
+	}
+	for _, i := range batch {
+		results[i].Error = fmt.Sprintf("failed to create short URL after %d attempts", maxGenerateAttmept)
+	}
+}
+
+// ValidateAlias checks a user chosen short code against the characters and
+// length the redirect route and the urls table accept.
+func ValidateAlias(alias string) error {
+	if len(alias) > MaxCodeLength {
+		return customerrors.Invalidf("alias is longer than %d characters", MaxCodeLength)
+	}
+	if !aliasRegex.MatchString(alias) {
+		return customerrors.Invalidf("alias may only contain letters, digits, '-' and '_'")
+	}
+	if reservedAliases[strings.ToLower(alias)] {
+		return customerrors.Invalidf("alias %q is reserved", alias)
+	}
+	return nil
+}
+
+// NormalizeTags trims, lowercases and de-duplicates tags.
+func NormalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, customerrors.Invalidf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	if len(out) > maxTagsPerLink {
+		return nil, customerrors.Invalidf("a link can have at most %d tags", maxTagsPerLink)
+	}
+	return out, nil
+}
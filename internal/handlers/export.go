@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"context"
+	"io"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/export"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+// ExportLinks streams every link to w in the given format. Nothing is
+// buffered beyond one cursor page and the encoder's own buffer.
+func ExportLinks(ctx context.Context, DB *Storage.URLDB, w io.Writer, format string, withClicks bool) error {
+	writer, err := export.NewWriter(format, w, withClicks)
+	if err != nil {
+		return err
+	}
+	err = DB.ExportLinks(ctx, Storage.ExportOptions{WithClicks: withClicks}, writer.Write)
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+const maxFolderLength = 64
+
+// ValidateFolderName returns name trimmed, or an error if it is empty or too
+// long. Unlike tags, folder names keep their case.
+func ValidateFolderName(name string) (string, error) {
+	name = normalizeFolder(name)
+	if name == "" || len(name) > maxFolderLength {
+		return "", customerrors.Invalidf("folder names must be 1 to %d characters", maxFolderLength)
+	}
+	return name, nil
+}
+
+func CreateFolder(ctx context.Context, DB *Storage.URLDB, owner int64, name string) error {
+	name, err := ValidateFolderName(name)
+	if err != nil {
+		return err
+	}
+	return DB.CreateFolder(ctx, owner, name)
+}
+
+func RenameFolder(ctx context.Context, DB *Storage.URLDB, owner int64, from, to string) error {
+	to, err := ValidateFolderName(to)
+	if err != nil {
+		return err
+	}
+	return DB.RenameFolder(ctx, owner, normalizeFolder(from), to)
+}
+
+func DeleteFolder(ctx context.Context, DB *Storage.URLDB, owner int64, name string) error {
+	return DB.DeleteFolder(ctx, owner, normalizeFolder(name))
+}
+
+func Folders(ctx context.Context, DB *Storage.URLDB, owner int64) ([]Storage.Folder, error) {
+	return DB.Folders(ctx, owner)
+}
+
+func normalizeFolder(name string) string {
+	return strings.TrimSpace(name)
+}
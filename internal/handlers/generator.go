@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+)
+
+const (
+	// SafeAlphabet drops the characters that are easy to confuse when a code
+	// is read aloud or copied by hand (0/O/o, 1/l/I).
+	SafeAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// MaxCodeLength matches the width of the urls.short column.
+	MaxCodeLength = 10
+
+	StrategyRandom   = "random"
+	StrategyHash     = "hash"
+	StrategySequence = "sequence"
+	StrategyWords    = "words"
+)
+
+// CodeGenerator produces short codes. attempt starts at 0 and is increased by
+// the caller every time a generated code turns out to be taken, so
+// deterministic strategies can move on to a different code.
+type CodeGenerator interface {
+	Generate(longurl string, attempt int) (string, error)
+}
+
+// RandomGenerator draws Length characters uniformly from Alphabet.
+type RandomGenerator struct {
+	Alphabet string
+	Length   int
+}
+
+func (g *RandomGenerator) Generate(_ string, _ int) (string, error) {
+	alphabet := []rune(g.Alphabet)
+	if err := checkGeneratorParams(len(alphabet), g.Length); err != nil {
+		return "", err
+	}
+	sb := builderPool.Get().(*strings.Builder)
+	sb.Reset()
+	for range g.Length {
+		sb.WriteRune(alphabet[rand.IntN(len(alphabet))])
+	}
+	result := sb.String()
+	builderPool.Put(sb)
+	return result, nil
+}
+
+// HashGenerator derives the code from a SHA-256 of the long URL, so the same
+// URL always maps to the same code. Salt keeps codes unguessable across
+// deployments; the attempt number is mixed in to resolve collisions.
+type HashGenerator struct {
+	Alphabet string
+	Length   int
+	Salt     string
+}
+
+func (g *HashGenerator) Generate(longurl string, attempt int) (string, error) {
+	alphabet := []rune(g.Alphabet)
+	if err := checkGeneratorParams(len(alphabet), g.Length); err != nil {
+		return "", err
+	}
+	input := g.Salt + longurl
+	if attempt > 0 {
+		input = fmt.Sprintf("%s#%d", input, attempt)
+	}
+	sum := sha256.Sum256([]byte(input))
+	n := binary.BigEndian.Uint64(sum[:8])
+	return encodeNumber(n, alphabet, g.Length), nil
+}
+
+// SequenceGenerator hands out Hashids-style codes: a counter is scrambled
+// with a keyed permutation of the code space and encoded with an alphabet
+// shuffled by Salt, so consecutive IDs don't produce adjacent codes. Because
+// the permutation is a bijection, distinct counter values never share a code.
+// Next supplies the counter; it should be shared by every replica (see
+// Storage.NextCodeSequence). Without it the generator counts from 0 in
+// process, which only suits a single replica and tests.
+type SequenceGenerator struct {
+	Alphabet string
+	Length   int
+	Salt     string
+	Next     func() (uint64, error)
+
+	once     sync.Once
+	shuffled []rune
+	space    uint64
+	halfBits uint
+	keys     [feistelRounds]uint64
+	counter  atomic.Uint64
+}
+
+// feistelRounds is enough for codes to look unrelated to their counter; the
+// permutation only has to be a bijection, not a cipher.
+const feistelRounds = 4
+
+func NewSequenceGenerator(alphabet string, length int, salt string, next func() (uint64, error)) *SequenceGenerator {
+	return &SequenceGenerator{Alphabet: alphabet, Length: length, Salt: salt, Next: next}
+}
+
+func (g *SequenceGenerator) init() {
+	g.shuffled = shuffleAlphabet([]rune(g.Alphabet), g.Salt)
+	g.space = 1
+	for range g.Length {
+		next := g.space * uint64(len(g.shuffled))
+		if next/uint64(len(g.shuffled)) != g.space {
+			g.space = 0 // overflowed: use the whole uint64 range
+			break
+		}
+		g.space = next
+	}
+	width := 64
+	if g.space != 0 {
+		width = bits.Len64(g.space - 1)
+	}
+	g.halfBits = uint(width+1) / 2
+	sum := sha256.Sum256([]byte(g.Salt))
+	for i := range g.keys {
+		g.keys[i] = binary.BigEndian.Uint64(sum[i*8:])
+	}
+}
+
+func (g *SequenceGenerator) Generate(_ string, _ int) (string, error) {
+	if err := checkGeneratorParams(len([]rune(g.Alphabet)), g.Length); err != nil {
+		return "", err
+	}
+	g.once.Do(g.init)
+	var n uint64
+	if g.Next != nil {
+		var err error
+		if n, err = g.Next(); err != nil {
+			return "", err
+		}
+	} else {
+		n = g.counter.Add(1) - 1
+	}
+	if g.space != 0 && n >= g.space {
+		return "", customerrors.Unavailable(fmt.Sprintf("all %d sequence codes of length %d are used up", g.space, g.Length))
+	}
+	return encodeNumber(g.permute(n), g.shuffled, g.Length), nil
+}
+
+// permute maps [0, space) onto itself. The Feistel network is a bijection on
+// numbers of 2*halfBits bits, which covers space with less than four times
+// as many values; cycle-walking applies it again until the result lands back
+// in range, which keeps it a bijection on the smaller set.
+func (g *SequenceGenerator) permute(n uint64) uint64 {
+	for {
+		n = g.feistel(n)
+		if g.space == 0 || n < g.space {
+			return n
+		}
+	}
+}
+
+func (g *SequenceGenerator) feistel(n uint64) uint64 {
+	mask := uint64(1)<<g.halfBits - 1
+	left, right := n>>g.halfBits, n&mask
+	for _, key := range g.keys {
+		left, right = right, left^(mix64(right^key)&mask)
+	}
+	return left<<g.halfBits | right
+}
+
+// mix64 is the splitmix64 finalizer.
+func mix64(z uint64) uint64 {
+	z = (z ^ z>>30) * 0xBF58476D1CE4E5B9
+	z = (z ^ z>>27) * 0x94D049BB133111EB
+	return z ^ z>>31
+}
+
+var (
+	wordConsonants = []rune("bdfghjklmnprstvz")
+	wordVowels     = []rune("aeiou")
+)
+
+// WordGenerator builds pronounceable codes out of consonant-vowel syllables,
+// e.g. "kobaremi". Length is the number of characters, capped at
+// MaxCodeLength.
+type WordGenerator struct {
+	Length int
+}
+
+func (g *WordGenerator) Generate(_ string, _ int) (string, error) {
+	if err := checkGeneratorParams(len(wordVowels), g.Length); err != nil {
+		return "", err
+	}
+	sb := builderPool.Get().(*strings.Builder)
+	sb.Reset()
+	for i := range g.Length {
+		if i%2 == 0 {
+			sb.WriteRune(wordConsonants[rand.IntN(len(wordConsonants))])
+		} else {
+			sb.WriteRune(wordVowels[rand.IntN(len(wordVowels))])
+		}
+	}
+	result := sb.String()
+	builderPool.Put(sb)
+	return result, nil
+}
+
+func checkGeneratorParams(alphabetSize, length int) error {
+	if alphabetSize < 2 {
+		return fmt.Errorf("code generator alphabet needs at least 2 characters")
+	}
+	if length < 1 || length > MaxCodeLength {
+		return fmt.Errorf("code length must be between 1 and %d, got %d", MaxCodeLength, length)
+	}
+	return nil
+}
+
+func encodeNumber(n uint64, alphabet []rune, length int) string {
+	base := uint64(len(alphabet))
+	code := make([]rune, length)
+	for i := length - 1; i >= 0; i-- {
+		code[i] = alphabet[n%base]
+		n /= base
+	}
+	return string(code)
+}
+
+// shuffleAlphabet is the consistent shuffle used by Hashids.
+func shuffleAlphabet(alphabet []rune, salt string) []rune {
+	out := make([]rune, len(alphabet))
+	copy(out, alphabet)
+	s := []rune(salt)
+	if len(s) == 0 {
+		return out
+	}
+	for i, v, p := len(out)-1, 0, 0; i > 0; i-- {
+		v %= len(s)
+		p += int(s[v])
+		j := (int(s[v]) + v + p) % i
+		out[i], out[j] = out[j], out[i]
+		v++
+	}
+	return out
+}
+
+var (
+	generatorsMu     sync.RWMutex
+	generators       = map[string]CodeGenerator{}
+	defaultGenerator = StrategyRandom
+)
+
+func init() {
+	ConfigureGenerators(Alphabet, NumberOfChrs, "", nil)
+}
+
+// ConfigureGenerators (re)registers the built-in strategies with the given
+// alphabet, code length and salt. sequence is the counter behind the
+// sequence strategy; nil counts in process.
+func ConfigureGenerators(alphabet string, length int, salt string, sequence func() (uint64, error)) error {
+	if err := checkGeneratorParams(len([]rune(alphabet)), length); err != nil {
+		return err
+	}
+	RegisterGenerator(StrategyRandom, &RandomGenerator{Alphabet: alphabet, Length: length})
+	RegisterGenerator(StrategyHash, &HashGenerator{Alphabet: alphabet, Length: length, Salt: salt})
+	RegisterGenerator(StrategySequence, NewSequenceGenerator(alphabet, length, salt, sequence))
+	RegisterGenerator(StrategyWords, &WordGenerator{Length: max(length, 8)})
+	return nil
+}
+
+// RegisterGenerator makes a strategy selectable by name, replacing any
+// generator previously registered under the same name.
+func RegisterGenerator(name string, gen CodeGenerator) {
+	generatorsMu.Lock()
+	defer generatorsMu.Unlock()
+	generators[name] = gen
+}
+
+// SetDefaultGenerator selects the strategy used when a request doesn't ask
+// for one.
+func SetDefaultGenerator(name string) error {
+	generatorsMu.Lock()
+	defer generatorsMu.Unlock()
+	if _, ok := generators[name]; !ok {
+		return fmt.Errorf("unknown code generator %q", name)
+	}
+	defaultGenerator = name
+	return nil
+}
+
+// GeneratorByName returns the named strategy, or the default one when name
+// is empty.
+func GeneratorByName(name string) (CodeGenerator, error) {
+	generatorsMu.RLock()
+	defer generatorsMu.RUnlock()
+	if name == "" {
+		name = defaultGenerator
+	}
+	gen, ok := generators[name]
+	if !ok {
+		return nil, customerrors.Invalidf("unknown code generator %q", name)
+	}
+	return gen, nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+// Health answers the load balancer's probes and the operators' status page.
+type Health struct {
+	DB       *Storage.URLDB
+	build    BuildInfo
+	started  time.Time
+	draining atomic.Bool
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// NewHealth reports version as the build's version; the commit is read from
+// the binary's VCS stamp when it has one.
+func NewHealth(DB *Storage.URLDB, version string) *Health {
+	build := BuildInfo{Version: version}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build.GoVersion = info.GoVersion
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				build.Commit = s.Value
+			}
+		}
+	}
+	return &Health{DB: DB, build: build, started: time.Now()}
+}
+
+// SetDraining makes readiness fail from now on, so the load balancer stops
+// sending requests while the ones in flight finish.
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+type ReadyReport struct {
+	Ready                bool                 `json:"ready"`
+	Draining             bool                 `json:"draining,omitempty"`
+	InsertQueueSaturated bool                 `json:"insert_queue_saturated,omitempty"`
+	Dependencies         Storage.Dependencies `json:"dependencies,omitempty"`
+}
+
+// Ready checks that the replica can take traffic: it isn't shutting down,
+// Postgres and Redis answer, and the insert queue has room.
+func (h *Health) Ready(ctx context.Context) ReadyReport {
+	if h.draining.Load() {
+		return ReadyReport{Draining: true}
+	}
+	report := ReadyReport{
+		Dependencies:         h.DB.PingDependencies(ctx),
+		InsertQueueSaturated: h.DB.InsertQueueSaturated(),
+	}
+	report.Ready = report.Dependencies.Healthy() && !report.InsertQueueSaturated
+	return report
+}
+
+type StatusReport struct {
+	ReadyReport
+	Build                 BuildInfo           `json:"build"`
+	UptimeSeconds         int64               `json:"uptime_seconds"`
+	InsertQueue           Storage.InsertStats `json:"insert_queue"`
+	Cache                 Storage.CacheStats  `json:"cache"`
+	Lookups               Storage.LookupStats `json:"lookups"`
+	InvalidationConnected bool                `json:"invalidation_connected"`
+}
+
+// Status is Ready plus what an operator needs to see why.
+func (h *Health) Status(ctx context.Context) StatusReport {
+	return StatusReport{
+		ReadyReport:           h.Ready(ctx),
+		Build:                 h.build,
+		UptimeSeconds:         int64(time.Since(h.started).Seconds()),
+		InsertQueue:           h.DB.InsertStats(),
+		Cache:                 h.DB.Cache.Stats(),
+		Lookups:               h.DB.LookupStats(),
+		InvalidationConnected: h.DB.InvalidationConnected(),
+	}
+}
@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+)
+
+const (
+	ImportSourceBitly   = "bitly"
+	ImportSourceYOURLS  = "yourls"
+	ImportSourceGeneric = "csv"
+
+	importChunkSize = 1000
+	jobKindImport   = "import"
+
+	// Running jobs have their lease renewed every jobLeaseRenewal; a job
+	// whose lease is older than jobLease is taken to be abandoned.
+	jobLeaseRenewal = 30 * time.Second
+	jobLease        = 4 * jobLeaseRenewal
+)
+
+var (
+	ErrImportQueueFull = &customerrors.Error{
+		Kind:       customerrors.KindUnavailable,
+		Message:    "import queue is full, try again later",
+		RetryAfter: time.Minute,
+	}
+	ErrUnknownImportSrc = customerrors.New(customerrors.KindInvalid, "unknown import source")
+
+	// Only codes made of our own alphabet are preserved; anything else would
+	// not survive the redirect route or look out of place next to ours.
+	preservableCode = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+)
+
+// importColumns lists, per source, the header names that may hold the short
+// code and the destination URL, in order of preference.
+var importColumns = map[string]struct {
+	code []string
+	long []string
+}{
+	ImportSourceBitly: {
+		code: []string{"link", "bitlink", "short_url", "short url", "id"},
+		long: []string{"long_url", "long url", "destination", "url"},
+	},
+	ImportSourceYOURLS: {
+		code: []string{"keyword", "shorturl"},
+		long: []string{"url", "long_url"},
+	},
+	ImportSourceGeneric: {
+		code: []string{"code", "short", "alias", "short_code", "keyword"},
+		long: []string{"long_url", "url", "destination"},
+	},
+}
+
+type importRecord struct {
+	line int
+	code string
+	item BulkItem
+}
+
+type importTask struct {
+	jobID  int64
+	source string
+	// path is the spooled upload, removed once the task has run.
+	path string
+	// identity owns the imported links and is charged for them.
+	identity auth.Identity
+	// logFields are the submitting request's, so the job's logs can be
+	// matched to it.
+	logFields []slog.Attr
+}
+
+// Importer runs imports from other shorteners in the background. Progress is
+// tracked in the jobs table so any replica can answer status requests.
+type Importer struct {
+	DB *Storage.URLDB
+	// instanceID identifies this replica's jobs in the jobs table.
+	instanceID string
+	queue      chan importTask
+	wg         sync.WaitGroup
+	stopLeases chan struct{}
+	leasesDone chan struct{}
+	// ctx is cancelled when Close runs out of time, interrupting the jobs
+	// still running.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// queueMu guards closed, so Submit never sends on the closed queue.
+	queueMu sync.Mutex
+	closed  bool
+}
+
+func NewImporter(DB *Storage.URLDB, workers, queueSize int) *Importer {
+	id := make([]byte, 8)
+	rand.Read(id)
+	ctx, cancel := context.WithCancel(context.Background())
+	im := &Importer{
+		DB:         DB,
+		instanceID: hex.EncodeToString(id),
+		queue:      make(chan importTask, queueSize),
+		stopLeases: make(chan struct{}),
+		leasesDone: make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	im.failAbandonedJobs()
+	go im.renewLeases()
+	im.startImportWorkers(workers)
+	return im
+}
+
+// renewLeases keeps this replica's jobs leased and fails jobs abandoned by
+// replicas that stopped, until Close.
+func (im *Importer) renewLeases() {
+	defer close(im.leasesDone)
+	ticker := time.NewTicker(jobLeaseRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-im.stopLeases:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), jobLeaseRenewal)
+		if err := im.DB.RenewJobLeases(ctx, im.instanceID); err != nil {
+			slog.Error("importer: renewing job leases failed", "err", err)
+		}
+		cancel()
+		im.failAbandonedJobs()
+	}
+}
+
+func (im *Importer) failAbandonedJobs() {
+	ctx, cancel := context.WithTimeout(context.Background(), jobLeaseRenewal)
+	defer cancel()
+	n, err := im.DB.FailInterruptedJobs(ctx, jobLease)
+	if err != nil {
+		slog.Error("importer: failing interrupted jobs", "err", err)
+		return
+	}
+	if n > 0 {
+		slog.Warn("importer: failed abandoned jobs", "count", n)
+	}
+}
+
+func ValidImportSource(source string) bool {
+	_, ok := importColumns[source]
+	return ok
+}
+
+// Submit records a new job and queues the file at path for import. Once
+// queued, the file belongs to the importer, which removes it after the job
+// runs; on error it is left to the caller. Imported links belong to the
+// caller and count against their quota.
+func (im *Importer) Submit(ctx context.Context, source, path string, id auth.Identity) (int64, error) {
+	if !ValidImportSource(source) {
+		return 0, ErrUnknownImportSrc
+	}
+	im.queueMu.Lock()
+	closed := im.closed
+	im.queueMu.Unlock()
+	if closed {
+		return 0, Storage.ErrShuttingDown
+	}
+	if len(im.queue) == cap(im.queue) {
+		return 0, ErrImportQueueFull
+	}
+	jobID, err := im.DB.CreateJob(ctx, im.instanceID, id.UserID, jobKindImport, source)
+	if err != nil {
+		return 0, err
+	}
+	task := importTask{jobID: jobID, source: source, path: path, identity: id, logFields: utils.LogFields(ctx)}
+
+	im.queueMu.Lock()
+	defer im.queueMu.Unlock()
+	if im.closed {
+		im.DB.FinishJob(ctx, jobID, Storage.JobFailed, Storage.ErrShuttingDown.Error())
+		return 0, Storage.ErrShuttingDown
+	}
+	select {
+	case im.queue <- task:
+		return jobID, nil
+	default:
+		im.DB.FinishJob(ctx, jobID, Storage.JobFailed, ErrImportQueueFull.Error())
+		return 0, ErrImportQueueFull
+	}
+}
+
+// Close stops accepting jobs and waits for queued ones to finish until ctx is
+// done; then the jobs left are interrupted and marked failed. Only the first
+// call does anything.
+func (im *Importer) Close(ctx context.Context) {
+	im.queueMu.Lock()
+	if im.closed {
+		im.queueMu.Unlock()
+		return
+	}
+	im.closed = true
+	close(im.queue)
+	im.queueMu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		im.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		im.cancel()
+		<-finished
+	}
+	im.cancel()
+
+	close(im.stopLeases)
+	<-im.leasesDone
+}
+
+func (im *Importer) startImportWorkers(n int) {
+	for i := range n {
+		im.wg.Add(1)
+		go func(workerID int) {
+			defer im.wg.Done()
+			for task := range im.queue {
+				im.runTask(workerID, task)
+			}
+		}(i)
+	}
+}
+
+func (im *Importer) runTask(workerID int, task importTask) {
+	ctx := utils.WithLogFields(im.ctx, task.logFields...)
+	defer func() {
+		if err := os.Remove(task.path); err != nil {
+			slog.ErrorContext(ctx, "import worker: removing upload failed", "worker", workerID, "job_id", task.jobID, "err", err)
+		}
+	}()
+	// finish records the outcome even once ctx is cancelled.
+	finish := func(status, jobErr string) {
+		finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := im.DB.FinishJob(finishCtx, task.jobID, status, jobErr); err != nil {
+			slog.ErrorContext(ctx, "import worker: job update failed", "worker", workerID, "job_id", task.jobID, "err", err)
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "import worker recovered from panic", "worker", workerID, "job_id", task.jobID, "panic", r)
+			finish(Storage.JobFailed, "internal error")
+		}
+	}()
+	if ctx.Err() != nil {
+		finish(Storage.JobFailed, "interrupted by a server shutdown")
+		return
+	}
+
+	file, err := os.Open(task.path)
+	if err != nil {
+		slog.ErrorContext(ctx, "import worker: opening upload failed", "worker", workerID, "job_id", task.jobID, "err", err)
+		finish(Storage.JobFailed, "internal error")
+		return
+	}
+	records, err := parseImport(task.source, file)
+	file.Close()
+	if err != nil {
+		finish(Storage.JobFailed, err.Error())
+		return
+	}
+	if err := im.DB.StartJob(ctx, task.jobID, len(records)); err != nil {
+		slog.ErrorContext(ctx, "import worker: job update failed", "worker", workerID, "job_id", task.jobID, "err", err)
+		return
+	}
+
+	gen, err := GeneratorByName("")
+	if err != nil {
+		finish(Storage.JobFailed, err.Error())
+		return
+	}
+	for start := 0; start < len(records); start += importChunkSize {
+		if ctx.Err() != nil {
+			finish(Storage.JobFailed, fmt.Sprintf("interrupted by a server shutdown after %d rows", start))
+			return
+		}
+		chunk := records[start:min(start+importChunkSize, len(records))]
+		// Charge each chunk up front, like a bulk create, and refund the
+		// rows that weren't created.
+		if err := ConsumeQuota(ctx, im.DB, task.identity, int64(len(chunk))); err != nil {
+			finish(Storage.JobFailed, fmt.Sprintf("stopped after %d rows: %v", start, err))
+			return
+		}
+		progress := importChunk(ctx, im.DB, gen, chunk, &task.identity.UserID)
+		// The chunk was written even if ctx was cancelled meanwhile, so its
+		// bookkeeping must be too.
+		written := context.WithoutCancel(ctx)
+		if err := ReleaseQuota(written, im.DB, task.identity, int64(progress.Failed)); err != nil {
+			slog.ErrorContext(ctx, "import worker: releasing quota failed", "worker", workerID, "job_id", task.jobID, "err", err)
+		}
+		if err := im.DB.AddJobProgress(written, task.jobID, progress); err != nil {
+			slog.ErrorContext(ctx, "import worker: job update failed", "worker", workerID, "job_id", task.jobID, "err", err)
+		}
+	}
+	finish(Storage.JobCompleted, "")
+}
+
+func importChunk(ctx context.Context, DB *Storage.URLDB, gen CodeGenerator, chunk []importRecord, owner *int64) Storage.JobProgress {
+	progress := Storage.JobProgress{Processed: len(chunk)}
+	items := make([]BulkItem, len(chunk))
+	for i, rec := range chunk {
+		items[i] = rec.item
+		if rec.code == "" {
+			continue
+		}
+		if preservableCode.MatchString(rec.code) && ValidateAlias(rec.code) == nil {
+			items[i].Alias = rec.code
+		} else {
+			progress.Issues = append(progress.Issues, Storage.JobIssue{
+				Line:    rec.line,
+				Kind:    "code_rewritten",
+				Code:    rec.code,
+				Message: "original code doesn't fit our character set or is reserved",
+			})
+		}
+	}
+
+	results := createBulk(ctx, DB, gen, items, owner, true)
+	for i, res := range results {
+		rec := chunk[i]
+		if res.Error != "" {
+			progress.Failed++
+			progress.Issues = append(progress.Issues, Storage.JobIssue{
+				Line:    rec.line,
+				Kind:    "failed",
+				Code:    rec.code,
+				Message: res.Error,
+			})
+			continue
+		}
+		progress.Imported++
+		if res.Conflict {
+			progress.Conflicts++
+			progress.Issues = append(progress.Issues, Storage.JobIssue{
+				Line:    rec.line,
+				Kind:    "conflict",
+				Code:    rec.code,
+				NewCode: res.Code,
+				Message: "original code is already taken",
+			})
+		}
+	}
+	return progress
+}
+
+// parseImport reads a CSV export. The header row decides which columns hold
+// the code and the destination; an optional "tags" column is "|" separated.
+func parseImport(source string, body io.Reader) ([]importRecord, error) {
+	columns := importColumns[source]
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, dup := index[name]; !dup {
+			index[name] = i
+		}
+	}
+	find := func(names []string) int {
+		for _, name := range names {
+			if i, ok := index[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	codeCol, longCol, tagsCol := find(columns.code), find(columns.long), find([]string{"tags"})
+	if longCol < 0 {
+		return nil, fmt.Errorf("CSV header has none of the destination columns %v", columns.long)
+	}
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var records []importRecord
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV line %d: %w", line, err)
+		}
+		rec := importRecord{
+			line: line,
+			code: importedCode(field(record, codeCol)),
+			item: BulkItem{LongURL: field(record, longCol)},
+		}
+		if raw := field(record, tagsCol); raw != "" {
+			rec.item.Tags = strings.Split(raw, "|")
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// importedCode turns "https://bit.ly/abc", "bit.ly/abc" or "abc" into "abc".
+func importedCode(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if !strings.Contains(raw, "://") && strings.Contains(raw, "/") {
+		raw = "https://" + raw
+	}
+	if parsed, err := url.Parse(raw); err == nil && parsed.Host != "" {
+		raw = parsed.Path
+	}
+	return strings.Trim(raw, "/")
+}
+
+// JobStatus returns the owner's job with the given ID.
+func JobStatus(DB *Storage.URLDB, id, owner int64) (*Storage.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return DB.GetJob(ctx, id, owner)
+}
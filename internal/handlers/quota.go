@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+)
+
+const (
+	PlanFree       = "free"
+	PlanPro        = "pro"
+	PlanEnterprise = "enterprise"
+)
+
+// Plan is a subscription tier. MonthlyLinks of 0 means unlimited.
+type Plan struct {
+	Name         string `json:"name"`
+	MonthlyLinks int64  `json:"monthly_links"`
+}
+
+var Plans = map[string]Plan{
+	PlanFree:       {Name: PlanFree, MonthlyLinks: 1000},
+	PlanPro:        {Name: PlanPro, MonthlyLinks: 100000},
+	PlanEnterprise: {Name: PlanEnterprise, MonthlyLinks: 0},
+}
+
+// QuotaExceeded describes a create that would pass plan's monthly quota:
+// 402 when upgrading would help, 429 until the month rolls over otherwise.
+func QuotaExceeded(plan Plan, used int64, reset time.Time) *customerrors.Error {
+	message := fmt.Sprintf("monthly quota of %d links on the %s plan is used up (%d used)", plan.MonthlyLinks, plan.Name, used)
+	fields := map[string]any{"plan": plan.Name, "limit": plan.MonthlyLinks, "used": used, "reset": reset}
+	if plan.Name == PlanFree {
+		return &customerrors.Error{Kind: customerrors.KindUpgradeRequired, Message: message, Fields: fields}
+	}
+	return &customerrors.Error{
+		Kind:       customerrors.KindQuotaExceeded,
+		Message:    message,
+		RetryAfter: time.Until(reset),
+		Fields:     fields,
+	}
+}
+
+type Usage struct {
+	Plan      Plan               `json:"plan"`
+	Period    time.Time          `json:"period"`
+	Used      int64              `json:"used"`
+	Remaining *int64             `json:"remaining"`
+	Reset     time.Time          `json:"reset"`
+	Keys      []Storage.KeyUsage `json:"keys"`
+}
+
+func userPlan(ctx context.Context, DB *Storage.URLDB, userID int64) (Plan, error) {
+	name, err := DB.UserPlan(ctx, userID)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan, ok := Plans[name]
+	if !ok {
+		// An unknown plan name is a data problem; don't lock the user out
+		// over it, but don't give them more than the free tier either.
+		return Plans[PlanFree], nil
+	}
+	return plan, nil
+}
+
+func quotaReset(now time.Time) time.Time {
+	return Storage.QuotaPeriod(now).AddDate(0, 1, 0)
+}
+
+// ConsumeQuota counts n links against the caller's monthly quota, or returns
+// the QuotaExceeded error if there isn't room for all of them.
+func ConsumeQuota(ctx context.Context, DB *Storage.URLDB, id auth.Identity, n int64) error {
+	plan, err := userPlan(ctx, DB, id.UserID)
+	if err != nil {
+		return err
+	}
+	ok, used, err := DB.ConsumeQuota(ctx, id.UserID, id.APIKeyID, n, plan.MonthlyLinks)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return QuotaExceeded(plan, used, quotaReset(time.Now()))
+	}
+	return nil
+}
+
+// ConsumeAnonymousQuota counts n links against the free plan's monthly quota
+// shared by callers without an API key at ip.
+func ConsumeAnonymousQuota(ctx context.Context, DB *Storage.URLDB, ip string, n int64) error {
+	plan := Plans[PlanFree]
+	ok, used, err := DB.ConsumeAnonymousQuota(ctx, ip, n, plan.MonthlyLinks)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return QuotaExceeded(plan, used, quotaReset(time.Now()))
+	}
+	return nil
+}
+
+// ReleaseQuota refunds links counted by ConsumeQuota that weren't created.
+func ReleaseQuota(ctx context.Context, DB *Storage.URLDB, id auth.Identity, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	return DB.ReleaseQuota(ctx, id.UserID, id.APIKeyID, n)
+}
+
+// ReleaseAnonymousQuota refunds links counted by ConsumeAnonymousQuota.
+func ReleaseAnonymousQuota(ctx context.Context, DB *Storage.URLDB, ip string, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	return DB.ReleaseAnonymousQuota(ctx, ip, n)
+}
+
+func GetUsage(ctx context.Context, DB *Storage.URLDB, userID int64) (Usage, error) {
+	plan, err := userPlan(ctx, DB, userID)
+	if err != nil {
+		return Usage{}, err
+	}
+	now := time.Now()
+	usage, err := DB.QuotaUsage(ctx, userID, now)
+	if err != nil {
+		return Usage{}, err
+	}
+	res := Usage{
+		Plan:   plan,
+		Period: usage.Period,
+		Used:   usage.Links,
+		Reset:  quotaReset(now),
+		Keys:   usage.Keys,
+	}
+	if plan.MonthlyLinks > 0 {
+		remaining := max(plan.MonthlyLinks-usage.Links, 0)
+		res.Remaining = &remaining
+	}
+	return res, nil
+}
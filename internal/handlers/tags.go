@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+type LinkPage struct {
+	Links      []Storage.Link `json:"links"`
+	NextCursor int64          `json:"next_cursor,omitempty"`
+}
+
+func ListLinks(ctx context.Context, DB *Storage.URLDB, owner int64, tag, folder string, cursor int64, limit int) (LinkPage, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	limit = min(limit, MaxListLimit)
+	links, next, err := DB.ListLinks(ctx, owner, normalizeTag(tag), normalizeFolder(folder), cursor, limit)
+	if err != nil {
+		return LinkPage{}, err
+	}
+	return LinkPage{Links: links, NextCursor: next}, nil
+}
+
+// EditLink changes the destination, replaces the tags and/or moves one of
+// owner's links to another folder. A nil tags or folder leaves them
+// untouched; an empty folder takes the link out of its folder.
+func EditLink(ctx context.Context, DB *Storage.URLDB, owner int64, short, newlong string, tags []string, folder *string) error {
+	linkOwner, err := DB.LinkOwner(ctx, short)
+	if err != nil {
+		return err
+	}
+	if linkOwner == nil || *linkOwner != owner {
+		return Storage.ErrNotFound
+	}
+	if newlong != "" {
+		if _, err := EditLongURL(DB, short, newlong); err != nil {
+			return err
+		}
+	}
+	if folder != nil {
+		if err := DB.SetLinkFolder(ctx, owner, short, normalizeFolder(*folder)); err != nil {
+			return err
+		}
+	}
+	if tags != nil {
+		normalized, err := NormalizeTags(tags)
+		if err != nil {
+			return err
+		}
+		return DB.SetLinkTags(ctx, owner, short, normalized)
+	}
+	return nil
+}
+
+func RenameTag(ctx context.Context, DB *Storage.URLDB, owner int64, from, to string) error {
+	to = normalizeTag(to)
+	if to == "" || len(to) > maxTagLength {
+		return customerrors.Invalidf("tag names must be 1 to %d characters", maxTagLength)
+	}
+	return DB.RenameTag(ctx, owner, normalizeTag(from), to)
+}
+
+func MergeTags(ctx context.Context, DB *Storage.URLDB, owner int64, sources []string, target string) error {
+	target = normalizeTag(target)
+	if target == "" || len(target) > maxTagLength {
+		return customerrors.Invalidf("tag names must be 1 to %d characters", maxTagLength)
+	}
+	normalized, err := NormalizeTags(sources)
+	if err != nil {
+		return err
+	}
+	if len(normalized) == 0 {
+		return customerrors.Invalidf("no tags to merge")
+	}
+	return DB.MergeTags(ctx, owner, normalized, target)
+}
+
+func TagStats(ctx context.Context, DB *Storage.URLDB, owner int64) ([]Storage.TagStat, error) {
+	return DB.TagStats(ctx, owner)
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
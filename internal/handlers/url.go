@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"math/rand/v2"
 	"strings"
 	"sync"
 	"time"
 
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
 	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
 	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
 )
@@ -27,24 +29,40 @@ var builderPool = sync.Pool{
 }
 
 func Shortner(longurl string) (string, error) {
-	err := utils.ValidateURL(longurl)
+	gen, err := GeneratorByName("")
 	if err != nil {
 		return "", err
 	}
-	sb := builderPool.Get().(*strings.Builder)
-	sb.Reset()
-	for range NumberOfChrs {
-		sb.WriteRune(AlphabetRunes[rand.IntN(len(AlphabetRunes))])
+	return ShortnerWith(gen, longurl, 0)
+}
+
+func ShortnerWith(gen CodeGenerator, longurl string, attempt int) (string, error) {
+	err := utils.ValidateURL(longurl)
+	if err != nil {
+		return "", err
 	}
-	result := sb.String()
-	builderPool.Put(sb)
-	return result, nil
+	return gen.Generate(longurl, attempt)
+}
+
+type CreateOptions struct {
+	// Strategy names the code generator; empty means the default one.
+	Strategy string
+	Tags     []string
+	OwnerID  *int64
 }
 
-func CreateShortURL(DB *Storage.URLDB, longurl string) (string, error) {
+func CreateShortURL(ctx context.Context, DB *Storage.URLDB, longurl string, opts CreateOptions) (string, error) {
 	const maxGenerateAttmept = 10
-	for range maxGenerateAttmept {
-		ShortURL, err := Shortner(longurl)
+	gen, err := GeneratorByName(opts.Strategy)
+	if err != nil {
+		return "", err
+	}
+	tags, err := NormalizeTags(opts.Tags)
+	if err != nil {
+		return "", err
+	}
+	for attempt := range maxGenerateAttmept {
+		ShortURL, err := ShortnerWith(gen, longurl, attempt)
 		if err != nil {
 			return "", err
 		}
@@ -53,18 +71,48 @@ func CreateShortURL(DB *Storage.URLDB, longurl string) (string, error) {
 			return "", err
 		}
 		if exists {
+			// A hashed code that already points at the same URL for the
+			// same owner is reused rather than treated as a collision.
+			// Someone else's link is never handed out, since the caller
+			// couldn't edit or delete it.
+			if _, ok := gen.(*HashGenerator); ok && reusable(ctx, DB, ShortURL, longurl, opts.OwnerID) {
+				return ShortURL, nil
+			}
 			continue
 		}
 		for range maximum_tries {
-			err := DB.SaveURL(ShortURL, longurl)
+			err := DB.SaveURL(ctx, Storage.NewURL{
+				Short:   ShortURL,
+				Long:    longurl,
+				Tags:    tags,
+				OwnerID: opts.OwnerID,
+			})
 			if err == nil {
 				return ShortURL, nil
 			}
+			if errors.Is(err, Storage.ErrShuttingDown) {
+				return "", err
+			}
 			time.Sleep(Try_delay)
 		}
 
 	}
-	return "", fmt.Errorf("failed to create short URL after %d attempts", maxGenerateAttmept)
+	return "", customerrors.Unavailable(fmt.Sprintf("failed to create short URL after %d attempts", maxGenerateAttmept))
+}
+
+func reusable(ctx context.Context, DB *Storage.URLDB, short, longurl string, owner *int64) bool {
+	existing, err := DB.GetURL(ctx, short)
+	if err != nil || existing != longurl {
+		return false
+	}
+	linkOwner, err := DB.LinkOwner(ctx, short)
+	if err != nil {
+		return false
+	}
+	if owner == nil || linkOwner == nil {
+		return owner == nil && linkOwner == nil
+	}
+	return *owner == *linkOwner
 }
 
 func DeleteShortURL(DB *Storage.URLDB, shorturl string) error {
@@ -73,7 +121,7 @@ func DeleteShortURL(DB *Storage.URLDB, shorturl string) error {
 		return err
 	}
 	if !exists {
-		return fmt.Errorf("the provided url isn't found")
+		return Storage.ErrNotFound
 	}
 	for range 3 {
 		err := DB.DeleteURL(shorturl)
@@ -85,28 +133,28 @@ func DeleteShortURL(DB *Storage.URLDB, shorturl string) error {
 	return fmt.Errorf("failed to delte shorturl after %d tries", maximum_tries)
 }
 
-func GetLongURL(DB *Storage.URLDB, shorturl string) (string, error) {
-	exists, err := DB.CheckShortURLExists(shorturl)
+// GetLongURL resolves shorturl and counts the click. Unknown codes return
+// Storage.ErrNotFound and expired ones Storage.ErrExpired.
+func GetLongURL(ctx context.Context, DB *Storage.URLDB, shorturl string) (string, error) {
+	longURL, err := DB.GetURL(ctx, shorturl)
 	if err != nil {
 		return "", err
 	}
-	if !exists {
-		return "", fmt.Errorf("there is no url associated with this short url")
-	}
-	longURL, err := DB.GetURL(shorturl)
-	if err != nil {
-		return "", fmt.Errorf("there is no url associated with this short url")
-	}
+	DB.RecordClick(shorturl)
 	return longURL, nil
 }
 
 func EditLongURL(DB *Storage.URLDB, shorturl string, newlong string) (string, error) {
+	err := utils.ValidateURL(newlong)
+	if err != nil {
+		return "", err
+	}
 	exists, err := DB.CheckShortURLExists(shorturl)
 	if err != nil {
 		return "", err
 	}
 	if !exists {
-		return "", fmt.Errorf("there is no url associated with this long url")
+		return "", Storage.ErrNotFound
 	}
 	err = DB.EditURL(shorturl, newlong)
 	if err != nil {
@@ -1 +1,120 @@
 package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+)
+
+const minPasswordLength = 8
+
+var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+// RegisterUser creates an account and its first API key.
+func RegisterUser(ctx context.Context, DB *Storage.URLDB, username, password string) (int64, string, error) {
+	if !usernameRegex.MatchString(username) {
+		return 0, "", customerrors.Invalidf("username must be 3 to 32 letters, digits, '.', '-' or '_'")
+	}
+	if len(password) < minPasswordLength {
+		return 0, "", customerrors.Invalidf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return 0, "", err
+	}
+	userID, err := DB.CreateUser(ctx, username, hash)
+	if err != nil {
+		return 0, "", err
+	}
+	key, err := newAPIKey(ctx, DB, userID, "default")
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, key, nil
+}
+
+// IssueAPIKey checks the user's password and returns a new API key.
+func IssueAPIKey(ctx context.Context, DB *Storage.URLDB, username, password, name string) (string, error) {
+	userID, hash, err := DB.UserCredentials(ctx, username)
+	if errors.Is(err, Storage.ErrUserNotFound) {
+		return "", auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := auth.CheckPassword(hash, password); err != nil {
+		return "", err
+	}
+	return newAPIKey(ctx, DB, userID, name)
+}
+
+func newAPIKey(ctx context.Context, DB *Storage.URLDB, userID int64, name string) (string, error) {
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return "", err
+	}
+	if _, err := DB.CreateAPIKey(ctx, userID, hash, name); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// apiKeyCacheTTL is how long a resolved or rejected key is remembered, so a
+// client sending the same key on every request doesn't query Postgres each
+// time.
+const apiKeyCacheTTL = 30 * time.Second
+
+// IdentityResolver looks API keys up in the database for auth.Middleware.
+func IdentityResolver(DB *Storage.URLDB) auth.Resolver {
+	return CachedResolver(func(ctx context.Context, keyHash string) (auth.Identity, error) {
+		userID, keyID, err := DB.ResolveAPIKey(ctx, keyHash)
+		if errors.Is(err, Storage.ErrAPIKeyNotFound) {
+			return auth.Identity{}, auth.ErrInvalidAPIKey
+		}
+		if err != nil {
+			return auth.Identity{}, err
+		}
+		return auth.Identity{UserID: userID, APIKeyID: keyID}, nil
+	})
+}
+
+// CachedResolver remembers what resolve answered for each key for
+// apiKeyCacheTTL, rejections included. Other errors aren't remembered.
+func CachedResolver(resolve auth.Resolver) auth.Resolver {
+	cache := Storage.NewCache(Storage.CacheOptions{
+		MaxEntries:      10000,
+		Shards:          16,
+		CleanupInterval: time.Minute,
+	})
+	return func(ctx context.Context, keyHash string) (auth.Identity, error) {
+		if cached, ok := cache.Get(keyHash); ok {
+			return parseCachedIdentity(cached)
+		}
+		id, err := resolve(ctx, keyHash)
+		if errors.Is(err, auth.ErrInvalidAPIKey) {
+			cache.Set(keyHash, "", apiKeyCacheTTL)
+			return auth.Identity{}, err
+		}
+		if err != nil {
+			return auth.Identity{}, err
+		}
+		cache.Set(keyHash, fmt.Sprintf("%d:%d", id.UserID, id.APIKeyID), apiKeyCacheTTL)
+		return id, nil
+	}
+}
+
+// parseCachedIdentity reads an entry written by CachedResolver; "" marks a
+// rejected key.
+func parseCachedIdentity(cached string) (auth.Identity, error) {
+	var id auth.Identity
+	if _, err := fmt.Sscanf(cached, "%d:%d", &id.UserID, &id.APIKeyID); err != nil {
+		return auth.Identity{}, auth.ErrInvalidAPIKey
+	}
+	return id, nil
+}
@@ -0,0 +1,237 @@
+// Package metrics exposes the service's Prometheus metrics. Request metrics
+// are recorded as requests complete; everything else is read from the
+// components' own stats when scraped, so they carry no Prometheus code.
+//
+// Cache hit ratios per tier come from the lookup counters, e.g.
+//
+//	rate(urlshortner_cache_lookups_total{result="hit"}[5m])
+//	  / ignoring(result) sum without(result) (rate(urlshortner_cache_lookups_total[5m]))
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "urlshortner"
+
+// NewRegistry returns a registry with the Go runtime and process collectors.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+type HTTPMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func NewHTTPMetrics(reg prometheus.Registerer) *HTTPMetrics {
+	labels := []string{"route", "method", "status"}
+	m := &HTTPMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern, method and status.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern, method and status.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, labels),
+	}
+	reg.MustRegister(m.requests, m.duration)
+	return m
+}
+
+// Middleware records each request under its chi route pattern rather than
+// its path, so /api/{id} is one series instead of one per short code.
+func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			labels := prometheus.Labels{"route": route, "method": r.Method, "status": strconv.Itoa(status)}
+			m.requests.With(labels).Inc()
+			m.duration.With(labels).Observe(time.Since(start).Seconds())
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// statsCollector turns a stats snapshot into metrics at scrape time.
+type statsCollector struct {
+	descs   []*prometheus.Desc
+	collect func(ch chan<- prometheus.Metric)
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d
+	}
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch)
+}
+
+func desc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+}
+
+func counter(d *prometheus.Desc, v uint64, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+}
+
+func gauge(d *prometheus.Desc, v float64, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+}
+
+// RegisterStorage exposes the cache tiers, the insert queue and the Postgres
+// pool of DB.
+func RegisterStorage(reg prometheus.Registerer, DB *Storage.URLDB) {
+	var (
+		lookups     = desc("cache_lookups_total", "Cache lookups by tier (local, redis, negative, filter) and result.", "tier", "result")
+		evictions   = desc("cache_evictions_total", "Entries evicted from the local cache to stay within its bounds.")
+		expirations = desc("cache_expirations_total", "Entries removed from the local cache after their TTL.")
+		entries     = desc("cache_entries", "Entries in the local cache.")
+		cacheBytes  = desc("cache_bytes", "Approximate size of the local cache.")
+		coalesced   = desc("lookup_coalesced_total", "Lookups that waited on another request's fetch of the same code.")
+		refreshes   = desc("lookup_early_refreshes_total", "Cache entries refreshed shortly before expiring.")
+
+		queueDepth    = desc("insert_queue_depth", "Links waiting for the insert workers.")
+		queueCapacity = desc("insert_queue_capacity", "Size of the insert queue.")
+		inserts       = desc("inserts_total", "Links handled by the insert workers, by outcome.", "outcome")
+		retries       = desc("insert_retries_total", "Postgres insert attempts that were retried.")
+
+		poolConns    = desc("pgxpool_connections", "Postgres pool connections by state.", "state")
+		poolMax      = desc("pgxpool_max_connections", "Maximum size of the Postgres pool.")
+		poolAcquires = desc("pgxpool_acquires_total", "Connections acquired from the Postgres pool.")
+		poolEmpty    = desc("pgxpool_empty_acquires_total", "Acquires that had to wait because the pool was empty.")
+		poolCanceled = desc("pgxpool_canceled_acquires_total", "Acquires canceled by their context.")
+		poolWait     = desc("pgxpool_acquire_duration_seconds_total", "Time spent acquiring connections.")
+	)
+	reg.MustRegister(&statsCollector{
+		descs: []*prometheus.Desc{
+			lookups, evictions, expirations, entries, cacheBytes, coalesced, refreshes,
+			queueDepth, queueCapacity, inserts, retries,
+			poolConns, poolMax, poolAcquires, poolEmpty, poolCanceled, poolWait,
+		},
+		collect: func(ch chan<- prometheus.Metric) {
+			cache := DB.Cache.Stats()
+			ch <- counter(lookups, cache.Hits, "local", "hit")
+			ch <- counter(lookups, cache.Misses, "local", "miss")
+			ch <- counter(evictions, cache.Evictions)
+			ch <- counter(expirations, cache.Expirations)
+			ch <- gauge(entries, float64(cache.Entries))
+			ch <- gauge(cacheBytes, float64(cache.Bytes))
+
+			lookup := DB.LookupStats()
+			ch <- counter(lookups, lookup.RedisHits, "redis", "hit")
+			ch <- counter(lookups, lookup.RedisMisses, "redis", "miss")
+			ch <- counter(lookups, lookup.RedisErrors, "redis", "error")
+			ch <- counter(lookups, lookup.NegativeHits, "negative", "hit")
+			ch <- counter(lookups, lookup.FilterRejects, "filter", "reject")
+			ch <- counter(coalesced, lookup.Coalesced)
+			ch <- counter(refreshes, lookup.EarlyRefreshes)
+
+			queue := DB.InsertStats()
+			ch <- gauge(queueDepth, float64(queue.Depth))
+			ch <- gauge(queueCapacity, float64(queue.Capacity))
+			ch <- counter(inserts, queue.Inserted, "inserted")
+			ch <- counter(inserts, queue.Failed, "failed")
+			ch <- counter(inserts, queue.Dropped, "dropped")
+			ch <- counter(retries, queue.Retries)
+
+			pool := DB.DB.Stat()
+			ch <- gauge(poolConns, float64(pool.AcquiredConns()), "acquired")
+			ch <- gauge(poolConns, float64(pool.IdleConns()), "idle")
+			ch <- gauge(poolConns, float64(pool.ConstructingConns()), "constructing")
+			ch <- gauge(poolMax, float64(pool.MaxConns()))
+			ch <- counter(poolAcquires, uint64(pool.AcquireCount()))
+			ch <- counter(poolEmpty, uint64(pool.EmptyAcquireCount()))
+			ch <- counter(poolCanceled, uint64(pool.CanceledAcquireCount()))
+			ch <- prometheus.MustNewConstMetric(poolWait, prometheus.CounterValue, pool.AcquireDuration().Seconds())
+		},
+	})
+}
+
+// RegisterRateLimits exposes each policy's decisions and how the limiters
+// coped with Redis failures.
+func RegisterRateLimits(reg prometheus.Registerer, rls *middlewares.RateLimiters) {
+	var (
+		decisions   = desc("rate_limit_decisions_total", "Rate limit decisions by policy and result.", "policy", "result")
+		redisErrors = desc("rate_limit_redis_errors_total", "Redis errors seen by the rate limiters.")
+		trips       = desc("rate_limit_breaker_trips_total", "Times the rate limiters' circuit breaker opened.")
+		open        = desc("rate_limit_breaker_open", "1 while the rate limiters are using their fallback.")
+		fallback    = desc("rate_limit_fallback_decisions_total", "Decisions made without Redis, by failure mode and result.", "mode", "result")
+	)
+	reg.MustRegister(&statsCollector{
+		descs: []*prometheus.Desc{decisions, redisErrors, trips, open, fallback},
+		collect: func(ch chan<- prometheus.Metric) {
+			for name, s := range rls.PolicyStats() {
+				ch <- counter(decisions, s.Allowed, name, "allowed")
+				ch <- counter(decisions, s.Rejected, name, "rejected")
+			}
+			s := rls.Stats()
+			ch <- counter(redisErrors, s.RedisErrors)
+			ch <- counter(trips, s.BreakerTrips)
+			ch <- gauge(open, boolValue(s.BreakerOpen))
+			ch <- counter(fallback, s.FallbackAllowed, string(middlewares.FailLocal), "allowed")
+			ch <- counter(fallback, s.FallbackDenied, string(middlewares.FailLocal), "rejected")
+			ch <- counter(fallback, s.FailedOpen, string(middlewares.FailOpen), "allowed")
+			ch <- counter(fallback, s.FailedClosed, string(middlewares.FailClosed), "rejected")
+		},
+	})
+}
+
+// RegisterAccessLog exposes how many access log entries were written and
+// dropped.
+func RegisterAccessLog(reg prometheus.Registerer, al *middlewares.AccessLog) {
+	var (
+		entries   = desc("access_log_entries_total", "Access log entries by outcome.", "outcome")
+		rotations = desc("access_log_rotations_total", "Access log file rotations.")
+	)
+	reg.MustRegister(&statsCollector{
+		descs: []*prometheus.Desc{entries, rotations},
+		collect: func(ch chan<- prometheus.Metric) {
+			s := al.Stats()
+			ch <- counter(entries, s.Written, "written")
+			ch <- counter(entries, s.Dropped, "dropped")
+			ch <- counter(rotations, s.Rotations)
+		},
+	})
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,83 +1,282 @@
 package middlewares
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Access log formats. Common and Combined are the Apache/NCSA formats most
+// log tooling reads, each followed by the quoted request ID; JSON has one
+// object per line with a few more fields.
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
 )
 
-var (
-	filelogger *log.Logger
-	logChannel chan string
-	done       chan struct{}
+// What the access log does with an entry when its buffer is full.
+const (
+	// AccessLogDrop counts the entry as dropped; requests never wait on the
+	// disk.
+	AccessLogDrop = "drop"
+	// AccessLogBlock waits for room, slowing requests down to the speed of
+	// the disk rather than losing entries.
+	AccessLogBlock = "block"
 )
 
-func StartAsyncStreamLogger(buffersize int) {
-	logChannel = make(chan string, buffersize)
-	done = make(chan struct{})
-	logfile, err := os.OpenFile("Stream.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+type AccessLogOptions struct {
+	Path       string
+	Format     string
+	BufferSize int
+	OnFull     string
+	Rotation   RotationOptions
+}
+
+// AccessLog writes one line per request to a rotating file from a background
+// goroutine.
+type AccessLog struct {
+	format  string
+	block   bool
+	file    *rotatingFile
+	entries chan []byte
+	done    chan struct{}
+	// mu guards stopped, so no entry is sent on the closed channel.
+	mu      sync.RWMutex
+	stopped bool
+	written atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// AccessLogStats counts entries since the log was opened.
+type AccessLogStats struct {
+	Written   uint64 `json:"written"`
+	Dropped   uint64 `json:"dropped"`
+	Rotations uint64 `json:"rotations"`
+}
+
+// LogReport counts the entries written while the access log drained and
+// those lost since it started, either because the buffer was full or because
+// draining ran out of time.
+type LogReport struct {
+	Flushed uint64
+	Dropped uint64
+}
+
+func NewAccessLog(opts AccessLogOptions) (*AccessLog, error) {
+	switch opts.Format {
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", opts.Format)
+	}
+	if opts.OnFull != AccessLogDrop && opts.OnFull != AccessLogBlock {
+		return nil, fmt.Errorf("unknown access log policy %q", opts.OnFull)
+	}
+	file, err := openRotatingFile(opts.Path, opts.Rotation)
 	if err != nil {
-		log.Fatalf("Error Opening log file: %v", err)
-	}
-	filelogger = log.New(logfile, "", log.LstdFlags|log.Lshortfile)
-
-	go func() {
-		for {
-			select {
-			case logEntry := <-logChannel:
-				filelogger.Println(logEntry)
-			case <-done:
-				logfile.Close()
-				return
-			}
+		return nil, err
+	}
+
+	al := &AccessLog{
+		format:  opts.Format,
+		block:   opts.OnFull == AccessLogBlock,
+		file:    file,
+		entries: make(chan []byte, max(opts.BufferSize, 1)),
+		done:    make(chan struct{}),
+	}
+	go al.run()
+	return al, nil
+}
+
+func (al *AccessLog) run() {
+	defer close(al.done)
+	for entry := range al.entries {
+		if _, err := al.file.Write(entry); err != nil {
+			slog.Error("access log write failed", "err", err)
+			al.dropped.Add(1)
+			continue
+		}
+		al.written.Add(1)
+	}
+}
+
+func (al *AccessLog) Stats() AccessLogStats {
+	return AccessLogStats{
+		Written:   al.written.Load(),
+		Dropped:   al.dropped.Load(),
+		Rotations: al.file.Rotations(),
+	}
+}
+
+// Close stops taking entries and waits until the buffered ones are written
+// or ctx is done. Requests still finishing after Close count as dropped.
+func (al *AccessLog) Close(ctx context.Context) LogReport {
+	al.mu.Lock()
+	if al.stopped {
+		al.mu.Unlock()
+		return LogReport{}
+	}
+	al.stopped = true
+	written := al.written.Load()
+	close(al.entries)
+	al.mu.Unlock()
+
+	select {
+	case <-al.done:
+		if err := al.file.Close(); err != nil {
+			slog.Error("closing access log failed", "err", err)
 		}
-	}()
+	case <-ctx.Done():
+		al.dropped.Add(uint64(len(al.entries)))
+	}
+	return LogReport{
+		Flushed: al.written.Load() - written,
+		Dropped: al.dropped.Load(),
+	}
 }
 
-func StopAsyncStreamLogger() {
-	close(done)
-	close(logChannel)
+func (al *AccessLog) enqueue(ctx context.Context, entry []byte) {
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+	if al.stopped {
+		al.dropped.Add(1)
+		return
+	}
+	if al.block {
+		select {
+		case al.entries <- entry:
+		case <-ctx.Done():
+			al.dropped.Add(1)
+		}
+		return
+	}
+	select {
+	case al.entries <- entry:
+	default:
+		al.dropped.Add(1)
+	}
 }
 
-func FileLoggingMiddleware(next http.Handler) http.Handler {
+func (al *AccessLog) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
 		defer func() {
-			if err := recover(); err != nil {
+			err := recover()
+			if err != nil {
 				lrw.statusCode = http.StatusInternalServerError
-				log.Printf("PANIC: %v", err)
+				slog.ErrorContext(r.Context(), "panic serving request", "panic", err)
 			}
-
-			logEntry := fmt.Sprintf(
-				"%s %s %s %d %s %s %v",
-				r.RemoteAddr,
-				r.Method,
-				r.URL.Path,
-				lrw.statusCode,
-				r.UserAgent(),
-				r.Referer(),
-				time.Since(start),
-			)
-			select {
-			case logChannel <- logEntry:
-			default:
+			if err == http.ErrAbortHandler {
+				// Let net/http drop the connection so the client sees the
+				// response was cut short.
+				defer panic(err)
 			}
+			al.enqueue(r.Context(), al.formatEntry(r, lrw, start))
 		}()
 		next.ServeHTTP(lrw, r)
 	})
 }
 
-// loggingResponseWriter wraps http.ResponseWriter to capture status code
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type jsonAccessEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	UserID     int64     `json:"user_id,omitempty"`
+}
+
+func (al *AccessLog) formatEntry(r *http.Request, lrw *loggingResponseWriter, start time.Time) []byte {
+	// The identity is resolved further in, so it only reaches this request's
+	// context through the log fields (see LogIdentity).
+	var userID int64
+	if v, ok := utils.LogField(r.Context(), "user_id"); ok && v.Kind() == slog.KindInt64 {
+		userID = v.Int64()
+	}
+
+	if al.format == AccessLogJSON {
+		line, _ := json.Marshal(jsonAccessEntry{
+			Time:       start,
+			RemoteAddr: ClientIP(r),
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Status:     lrw.statusCode,
+			Bytes:      lrw.bytes,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			RequestID:  middleware.GetReqID(r.Context()),
+			UserID:     userID,
+		})
+		return append(line, '\n')
+	}
+
+	user := "-"
+	if userID != 0 {
+		user = strconv.FormatInt(userID, 10)
+	}
+	size := "-"
+	if lrw.bytes > 0 {
+		size = strconv.FormatInt(lrw.bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		ClientIP(r), user, start.Format(clfTimeFormat),
+		r.Method+" "+r.RequestURI+" "+r.Proto, lrw.statusCode, size)
+	if al.format == AccessLogCombined {
+		line += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+	}
+	// The request ID goes last, where log parsers expect extra fields.
+	line += fmt.Sprintf(" %q", orDash(middleware.GetReqID(r.Context())))
+	return []byte(line + "\n")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// loggingResponseWriter wraps http.ResponseWriter to capture the status code
+// and response size.
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.statusCode = code
 	lrw.ResponseWriter.WriteHeader(code)
 }
+
+func (lrw *loggingResponseWriter) Write(p []byte) (int, error) {
+	n, err := lrw.ResponseWriter.Write(p)
+	lrw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
@@ -0,0 +1,219 @@
+package middlewares
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailureMode is what a limiter does while Redis is unreachable.
+type FailureMode string
+
+const (
+	// FailLocal enforces the limit with an in-memory token bucket on each
+	// replica, so the effective limit is multiplied by the replica count
+	// until Redis is back.
+	FailLocal FailureMode = "local"
+	FailOpen  FailureMode = "open"
+	// FailClosed rejects every request.
+	FailClosed FailureMode = "closed"
+)
+
+var errCircuitOpen = errors.New("rate limit store unavailable")
+
+type BreakerOptions struct {
+	// FailureThreshold consecutive Redis errors open the breaker; after
+	// Cooldown one request is let through to probe whether Redis is back.
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+var DefaultBreakerOptions = BreakerOptions{
+	FailureThreshold: 5,
+	Cooldown:         5 * time.Second,
+}
+
+// RateLimitStats counts how limiters behaved while Redis was failing.
+type RateLimitStats struct {
+	RedisErrors     uint64 `json:"redis_errors"`
+	BreakerTrips    uint64 `json:"breaker_trips"`
+	BreakerOpen     bool   `json:"breaker_open"`
+	FallbackAllowed uint64 `json:"fallback_allowed"`
+	FallbackDenied  uint64 `json:"fallback_denied"`
+	FailedOpen      uint64 `json:"failed_open"`
+	FailedClosed    uint64 `json:"failed_closed"`
+}
+
+type limiterStats struct {
+	redisErrors     atomic.Uint64
+	breakerTrips    atomic.Uint64
+	fallbackAllowed atomic.Uint64
+	fallbackDenied  atomic.Uint64
+	failedOpen      atomic.Uint64
+	failedClosed    atomic.Uint64
+}
+
+// circuitBreaker stops limiters from waiting on a Redis that is down. It is
+// shared by every limiter using the same Redis client.
+type circuitBreaker struct {
+	opts     BreakerOptions
+	stats    *limiterStats
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(opts BreakerOptions) *circuitBreaker {
+	return &circuitBreaker{opts: opts, stats: &limiterStats{}}
+}
+
+// Allow reports whether a request should try Redis.
+func (cb *circuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !cb.open {
+		return true
+	}
+	if cb.probing || time.Since(cb.openedAt) < cb.opts.Cooldown {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+func (cb *circuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.open {
+		slog.Info("rate limiter: Redis recovered, leaving fallback mode")
+	}
+	cb.failures = 0
+	cb.open = false
+	cb.probing = false
+}
+
+func (cb *circuitBreaker) Failure(err error) {
+	cb.stats.redisErrors.Add(1)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	if cb.open {
+		// A failed probe: wait out another cooldown.
+		cb.openedAt = time.Now()
+		cb.probing = false
+		return
+	}
+	if cb.failures >= cb.opts.FailureThreshold {
+		cb.open = true
+		cb.openedAt = time.Now()
+		cb.stats.breakerTrips.Add(1)
+		slog.Warn("rate limiter: Redis failing, using fallback", "failures", cb.failures, "err", err)
+	}
+}
+
+func (cb *circuitBreaker) Stats() RateLimitStats {
+	cb.mu.Lock()
+	open := cb.open
+	cb.mu.Unlock()
+	return RateLimitStats{
+		RedisErrors:     cb.stats.redisErrors.Load(),
+		BreakerTrips:    cb.stats.breakerTrips.Load(),
+		BreakerOpen:     open,
+		FallbackAllowed: cb.stats.fallbackAllowed.Load(),
+		FallbackDenied:  cb.stats.fallbackDenied.Load(),
+		FailedOpen:      cb.stats.failedOpen.Load(),
+		FailedClosed:    cb.stats.failedClosed.Load(),
+	}
+}
+
+// localBuckets is the in-memory token bucket used by FailLocal.
+type localBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]*localBucket
+	rate    int
+	window  time.Duration
+}
+
+type localBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxLocalBuckets bounds memory during a long outage; full buckets are
+// dropped first since they behave the same as a fresh one.
+const maxLocalBuckets = 100000
+
+func newLocalBuckets(rate int, window time.Duration) *localBuckets {
+	return &localBuckets{
+		buckets: make(map[string]*localBucket),
+		rate:    rate,
+		window:  window,
+	}
+}
+
+func (lb *localBuckets) Allow(key string) Decision {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(lb.rate)
+	perToken := max(lb.window/time.Duration(lb.rate), time.Nanosecond)
+	b, ok := lb.buckets[key]
+	if !ok {
+		if len(lb.buckets) >= maxLocalBuckets {
+			lb.prune(now)
+		}
+		b = &localBucket{tokens: capacity, last: now}
+		lb.buckets[key] = b
+	}
+	b.tokens = min(capacity, b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+
+	d := Decision{Limit: lb.rate}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = time.Duration((capacity - b.tokens) * float64(perToken))
+	return d
+}
+
+func (lb *localBuckets) prune(now time.Time) {
+	for key, b := range lb.buckets {
+		if now.Sub(b.last) >= lb.window {
+			delete(lb.buckets, key)
+		}
+	}
+	if len(lb.buckets) >= maxLocalBuckets {
+		// Everyone is active; start over rather than grow without bound.
+		lb.buckets = make(map[string]*localBucket)
+	}
+}
+
+// fallback decides a request without Redis according to rl's failure mode.
+func (rl *Ratelimiter) fallback(key string, cause error) (Decision, error) {
+	stats := rl.breaker.stats
+	switch rl.onFailure {
+	case FailOpen:
+		stats.failedOpen.Add(1)
+		return Decision{Allowed: true, Limit: rl.rate, Remaining: rl.rate, Reset: rl.window}, nil
+	case FailClosed:
+		stats.failedClosed.Add(1)
+		return Decision{Limit: rl.rate, RetryAfter: rl.breaker.opts.Cooldown, Reset: rl.window}, cause
+	default:
+		d := rl.local.Allow(key)
+		if d.Allowed {
+			stats.fallbackAllowed.Add(1)
+		} else {
+			stats.fallbackDenied.Add(1)
+		}
+		return d, nil
+	}
+}
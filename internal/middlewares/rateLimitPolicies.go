@@ -0,0 +1,318 @@
+package middlewares
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/tracing"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+	"github.com/redis/go-redis/v9"
+)
+
+// Parts a policy can key its limit by. A policy listing several keys them by
+// the combination, so ["user", "ip"] limits each user separately per IP.
+const (
+	KeyByIP     = "ip"
+	KeyByAPIKey = "api_key"
+	KeyByUser   = "user"
+	// KeyByIdentity is the user for authenticated requests and the IP
+	// otherwise.
+	KeyByIdentity = "identity"
+)
+
+// Policy declares the limit for one class of routes.
+type Policy struct {
+	Name      string        `yaml:"name"`
+	Rate      int           `yaml:"rate"`
+	Window    time.Duration `yaml:"window"`
+	Algorithm Algorithm     `yaml:"algorithm"`
+	KeyBy     []string      `yaml:"key_by"`
+	// OnFailure defaults to FailLocal.
+	OnFailure FailureMode `yaml:"on_failure"`
+}
+
+var DefaultPolicies = []Policy{
+	{Name: "redirect", Rate: 1200, Window: time.Minute, Algorithm: GCRA, KeyBy: []string{KeyByIP}},
+	{Name: "create", Rate: 60, Window: time.Minute, Algorithm: SlidingWindow, KeyBy: []string{KeyByIdentity}},
+	// API keys are looked up before any route's own limit applies, so
+	// requests carrying one are limited by IP first.
+	{Name: "api_key", Rate: 1200, Window: time.Minute, Algorithm: GCRA, KeyBy: []string{KeyByIP}},
+	// Failing closed keeps password guessing throttled during an outage.
+	{Name: "auth", Rate: 10, Window: time.Minute, Algorithm: SlidingLog, KeyBy: []string{KeyByIP}, OnFailure: FailClosed},
+}
+
+// String describes p on one line, for logs.
+func (p Policy) String() string {
+	s := fmt.Sprintf("%s: %d per %s (%s, by %s)", p.Name, p.Rate, p.Window, p.Algorithm, strings.Join(p.KeyBy, "+"))
+	if p.OnFailure != "" {
+		s += ", fail " + string(p.OnFailure)
+	}
+	return s
+}
+
+func (p Policy) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("rate limit policy has no name")
+	}
+	if p.Rate <= 0 || p.Window <= 0 {
+		return fmt.Errorf("rate limit policy %q needs a positive rate and window", p.Name)
+	}
+	if _, ok := rateLimitScripts[p.Algorithm]; !ok {
+		return fmt.Errorf("rate limit policy %q: unknown algorithm %q", p.Name, p.Algorithm)
+	}
+	switch p.OnFailure {
+	case "", FailLocal, FailOpen, FailClosed:
+	default:
+		return fmt.Errorf("rate limit policy %q: unknown failure mode %q", p.Name, p.OnFailure)
+	}
+	if len(p.KeyBy) == 0 {
+		return fmt.Errorf("rate limit policy %q has no key", p.Name)
+	}
+	for _, part := range p.KeyBy {
+		switch part {
+		case KeyByIP, KeyByAPIKey, KeyByUser, KeyByIdentity:
+		default:
+			return fmt.Errorf("rate limit policy %q: unknown key %q", p.Name, part)
+		}
+	}
+	return nil
+}
+
+// ValidatePolicies checks each policy and that no two share a name.
+func ValidatePolicies(policies []Policy) error {
+	seen := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate rate limit policy %q", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return nil
+}
+
+// key builds the caller's bucket name from the policy's parts. A part the
+// request doesn't have (no API key, not signed in) is left empty, so all
+// such callers share it.
+func (p Policy) key(r *http.Request) string {
+	parts := make([]string, len(p.KeyBy))
+	for i, part := range p.KeyBy {
+		var value string
+		switch part {
+		case KeyByIP:
+			value = ClientIP(r)
+		case KeyByAPIKey:
+			if key := auth.APIKeyFromRequest(r); key != "" {
+				value = auth.HashAPIKey(key)
+			}
+		case KeyByUser:
+			if id, ok := auth.FromContext(r.Context()); ok {
+				value = strconv.FormatInt(id.UserID, 10)
+			}
+		case KeyByIdentity:
+			if id, ok := auth.FromContext(r.Context()); ok {
+				part, value = KeyByUser, strconv.FormatInt(id.UserID, 10)
+			} else {
+				part, value = KeyByIP, ClientIP(r)
+			}
+		}
+		parts[i] = part + "=" + value
+	}
+	return strings.Join(parts, ",")
+}
+
+// ClientIP is the caller's address without the port. Behind RealIP it is
+// the address the trusted proxies reported.
+func ClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// RateLimiters holds one limiter per policy, sharing a Redis connection and
+// the circuit breaker in front of it. The policies can be replaced while
+// serving with Update.
+type RateLimiters struct {
+	redisClient *redis.Client
+	breaker     *circuitBreaker
+	current     atomic.Pointer[limiterSet]
+	// counts outlive reloads, so they are kept apart from the limiters.
+	countsMu sync.Mutex
+	counts   map[string]*policyCounts
+}
+
+type policyCounts struct {
+	allowed  atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// PolicyStats counts a policy's decisions since the start.
+type PolicyStats struct {
+	Allowed  uint64 `json:"allowed"`
+	Rejected uint64 `json:"rejected"`
+}
+
+type limiterSet struct {
+	limiters map[string]*Ratelimiter
+	policies map[string]Policy
+}
+
+func NewRateLimiters(redisAddr string, policies []Policy) (*RateLimiters, error) {
+	if err := ValidatePolicies(policies); err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+		DB:   1,
+	})
+	if err := tracing.InstrumentRedis(rdb); err != nil {
+		return nil, err
+	}
+	rls := &RateLimiters{
+		redisClient: rdb,
+		breaker:     newCircuitBreaker(DefaultBreakerOptions),
+		counts:      make(map[string]*policyCounts),
+	}
+	rls.current.Store(rls.newSet(policies, nil))
+	return rls, nil
+}
+
+// Update swaps in a new set of policies. Limiters whose policy is unchanged
+// are kept, so their in-memory fallback buckets survive; counts in Redis are
+// kept either way.
+func (rls *RateLimiters) Update(policies []Policy) error {
+	if err := ValidatePolicies(policies); err != nil {
+		return err
+	}
+	rls.current.Store(rls.newSet(policies, rls.current.Load()))
+	return nil
+}
+
+func (rls *RateLimiters) newSet(policies []Policy, old *limiterSet) *limiterSet {
+	set := &limiterSet{
+		limiters: make(map[string]*Ratelimiter, len(policies)),
+		policies: make(map[string]Policy, len(policies)),
+	}
+	for _, p := range policies {
+		if p.OnFailure == "" {
+			p.OnFailure = FailLocal
+		}
+		set.policies[p.Name] = p
+		if old != nil && reflect.DeepEqual(old.policies[p.Name], p) {
+			set.limiters[p.Name] = old.limiters[p.Name]
+			continue
+		}
+		set.limiters[p.Name] = &Ratelimiter{
+			redisClient: rls.redisClient,
+			name:        p.Name,
+			rate:        p.Rate,
+			window:      p.Window,
+			algorithm:   p.Algorithm,
+			onFailure:   p.OnFailure,
+			breaker:     rls.breaker,
+			local:       newLocalBuckets(p.Rate, p.Window),
+		}
+	}
+	return set
+}
+
+// Middleware enforces the named policy. Routes whose policy isn't configured
+// are not limited. The policy is looked up per request, so an Update applies
+// to routes that are already set up.
+func (rls *RateLimiters) Middleware(name string) func(http.Handler) http.Handler {
+	counts := rls.countsFor(name)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			set := rls.current.Load()
+			policy, ok := set.policies[name]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if serveLimited(w, r, next, set.limiters[name], policy.key(r)) {
+				counts.allowed.Add(1)
+			} else {
+				counts.rejected.Add(1)
+			}
+		})
+	}
+}
+
+func (rls *RateLimiters) countsFor(name string) *policyCounts {
+	rls.countsMu.Lock()
+	defer rls.countsMu.Unlock()
+	c, ok := rls.counts[name]
+	if !ok {
+		c = &policyCounts{}
+		rls.counts[name] = c
+	}
+	return c
+}
+
+// PolicyStats returns the decisions made for each policy routes use.
+func (rls *RateLimiters) PolicyStats() map[string]PolicyStats {
+	rls.countsMu.Lock()
+	defer rls.countsMu.Unlock()
+	stats := make(map[string]PolicyStats, len(rls.counts))
+	for name, c := range rls.counts {
+		stats[name] = PolicyStats{Allowed: c.allowed.Load(), Rejected: c.rejected.Load()}
+	}
+	return stats
+}
+
+func (rls *RateLimiters) Stats() RateLimitStats {
+	return rls.breaker.Stats()
+}
+
+func (rls *RateLimiters) Close() error {
+	return rls.redisClient.Close()
+}
+
+// rateLimit sets the RateLimit-* headers from the IETF draft on every
+// response and Retry-After on rejections.
+func rateLimit(rl *Ratelimiter, key func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveLimited(w, r, next, rl, key(r))
+		})
+	}
+}
+
+// serveLimited reports whether the request was let through.
+func serveLimited(w http.ResponseWriter, r *http.Request, next http.Handler, rl *Ratelimiter, key string) bool {
+	decision, _ := rl.Allow(r.Context(), key)
+
+	header := w.Header()
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rl.rate, int(rl.window.Seconds())))
+	header.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	if !decision.Allowed {
+		retryAfter := time.Duration(ceilSeconds(decision.RetryAfter)) * time.Second
+		customerrors.Write(w, r, &customerrors.Error{
+			Kind:       customerrors.KindRateLimited,
+			Message:    "Too many requests. Try again in " + formatRetryString(retryAfter),
+			RetryAfter: retryAfter,
+		})
+		return false
+	}
+
+	next.ServeHTTP(w, r)
+	return true
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -5,19 +5,141 @@ import (
 	"fmt"
 	"math"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// Algorithm picks how a Ratelimiter counts requests. Each one runs as a
+// single Lua script, so concurrent requests can't race between reading and
+// updating the count, and all of them take the time from Redis, so replicas
+// with skewed clocks still agree.
+type Algorithm string
+
+const (
+	// SlidingLog keeps a timestamp per request: exact, but memory grows with
+	// the limit.
+	SlidingLog Algorithm = "sliding_log"
+	// SlidingWindow weights the previous fixed window's count by how much of
+	// it still overlaps the sliding window: two counters per key, close to
+	// exact.
+	SlidingWindow Algorithm = "sliding_window"
+	// GCRA is a token bucket holding up to rate tokens that refill evenly
+	// over the window, stored as a single timestamp.
+	GCRA Algorithm = "gcra"
+)
+
+// The scripts take the limit and window (in milliseconds) and return
+// {allowed, remaining, retry_after_ms, reset_ms}.
+var rateLimitScripts = map[Algorithm]*redis.Script{
+	SlidingLog: redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, now .. ':' .. count)
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+local retry = 0
+if allowed == 0 then
+	retry = reset
+end
+return {allowed, limit - count, retry, reset}
+`),
+	SlidingWindow: redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local index = math.floor(now / window)
+local elapsed = now - index * window
+local current = tonumber(redis.call('HGET', KEYS[1], tostring(index))) or 0
+local previous = tonumber(redis.call('HGET', KEYS[1], tostring(index - 1))) or 0
+local weight = (window - elapsed) / window
+local estimate = previous * weight + current
+local reset = window - elapsed
+
+if estimate + 1 > limit then
+	local retry = reset
+	if current < limit and previous > 0 then
+		-- The previous window's share shrinks as time passes; wait until it
+		-- leaves room for one more request.
+		retry = math.ceil(window * (1 - (limit - 1 - current) / previous) - elapsed)
+	end
+	return {0, 0, math.max(retry, 1), reset}
+end
+
+redis.call('HINCRBY', KEYS[1], tostring(index), 1)
+redis.call('HDEL', KEYS[1], tostring(index - 2))
+redis.call('PEXPIRE', KEYS[1], window * 2)
+return {1, math.floor(limit - estimate - 1), 0, reset}
+`),
+	GCRA: redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local interval = window / limit
+
+-- tat is the theoretical arrival time: when the bucket will be full again.
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+tat = math.max(tat, now)
+local newtat = tat + interval
+local allowAt = newtat - window
+
+if now < allowAt then
+	return {0, 0, math.ceil(allowAt - now), math.ceil(tat - now)}
+end
+
+redis.call('SET', KEYS[1], string.format('%d', math.ceil(newtat)), 'PX', math.max(math.ceil(newtat - now), 1))
+return {1, math.floor((window - (newtat - now)) / interval), 0, math.ceil(newtat - now)}
+`),
+}
+
+// redisLimitTimeout bounds each Redis round trip in Allow. It is well under
+// any request deadline so a slow Redis falls back instead of stalling
+// requests.
+const redisLimitTimeout = 500 * time.Millisecond
+
 type Ratelimiter struct {
 	redisClient *redis.Client
+	name        string
 	rate        int
 	window      time.Duration
+	algorithm   Algorithm
+	onFailure   FailureMode
+	breaker     *circuitBreaker
+	local       *localBuckets
 }
 
-func NewRateLimiter(redisAddr string, rate int, window time.Duration) *Ratelimiter {
+// Decision is the outcome of one Allow call.
+type Decision struct {
+	Allowed bool
+	Limit   int
+	// Remaining is how many more requests would be allowed right now.
+	Remaining int
+	// RetryAfter is how long a denied caller should wait; zero when allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the limit is fully available again (for
+	// SlidingWindow, until the current fixed window ends).
+	Reset time.Duration
+}
+
+func NewRateLimiter(redisAddr string, rate int, window time.Duration, algorithm Algorithm) *Ratelimiter {
 	rdb := redis.NewClient(&redis.Options{
 		Addr: redisAddr,
 		DB:   1,
@@ -27,53 +149,79 @@ func NewRateLimiter(redisAddr string, rate int, window time.Duration) *Ratelimit
 		redisClient: rdb,
 		rate:        rate,
 		window:      window,
+		algorithm:   algorithm,
+		onFailure:   FailLocal,
+		breaker:     newCircuitBreaker(DefaultBreakerOptions),
+		local:       newLocalBuckets(rate, window),
 	}
 }
 
-func (rl *Ratelimiter) allow(ip string) (bool, time.Duration) {
-	ctx := context.Background()
-	key := "rate:" + ip
-
-	// Check if key exists
-	tokensStr, err := rl.redisClient.Get(ctx, key).Result()
-	var tokens int
-	if err == redis.Nil {
-		// Not found, initialize
-		tokens = rl.rate - 1
-		rl.redisClient.Set(ctx, key, tokens, rl.window)
-		return true, 0
-	} else if err != nil {
-		// Redis error
-		return false, rl.window
-	}
+// WithFailureMode sets what rl does while Redis is unreachable; the default
+// is FailLocal.
+func (rl *Ratelimiter) WithFailureMode(mode FailureMode) *Ratelimiter {
+	rl.onFailure = mode
+	return rl
+}
+
+func (rl *Ratelimiter) WithBreaker(opts BreakerOptions) *Ratelimiter {
+	rl.breaker = newCircuitBreaker(opts)
+	return rl
+}
+
+func (rl *Ratelimiter) Stats() RateLimitStats {
+	return rl.breaker.Stats()
+}
 
-	tokens, _ = strconv.Atoi(tokensStr)
-	if tokens <= 0 {
-		ttl, _ := rl.redisClient.TTL(ctx, key).Result()
-		return false, ttl
+// Allow counts one request against key and reports whether it may proceed.
+// When Redis fails the decision comes from the failure mode instead; an
+// error is only returned along with a FailClosed rejection.
+func (rl *Ratelimiter) Allow(ctx context.Context, key string) (Decision, error) {
+	if !rl.breaker.Allow() {
+		return rl.fallback(key, errCircuitOpen)
 	}
+	// A client hanging up mid-request says nothing about Redis, so the call
+	// doesn't inherit ctx's cancellation and only its own timeout counts as
+	// a failure.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisLimitTimeout)
+	defer cancel()
+	d, err := rl.allowRedis(ctx, key)
+	if err != nil {
+		rl.breaker.Failure(err)
+		return rl.fallback(key, err)
+	}
+	rl.breaker.Success()
+	return d, nil
+}
 
-	// Decrement and update TTL
-	rl.redisClient.Decr(ctx, key)
+func (rl *Ratelimiter) allowRedis(ctx context.Context, key string) (Decision, error) {
+	script, ok := rateLimitScripts[rl.algorithm]
+	if !ok {
+		return Decision{}, fmt.Errorf("unknown rate limit algorithm %q", rl.algorithm)
+	}
+	redisKey := fmt.Sprintf("rate:%s:%s", rl.algorithm, key)
+	if rl.name != "" {
+		redisKey = fmt.Sprintf("rate:%s:%s:%s", rl.algorithm, rl.name, key)
+	}
+	res, err := script.Run(ctx, rl.redisClient, []string{redisKey}, rl.rate, rl.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("rate limit error: %w", err)
+	}
+	return Decision{
+		Allowed:    res[0] == 1,
+		Limit:      rl.rate,
+		Remaining:  int(max(res[1], 0)),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+		Reset:      time.Duration(res[3]) * time.Millisecond,
+	}, nil
+}
 
-	return true, 0
+func (rl *Ratelimiter) Close() error {
+	return rl.redisClient.Close()
 }
 
+// RateLimitMiddleware limits each client IP with rl.
 func RateLimitMiddleware(rl *Ratelimiter) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
-
-			allowed, retryAfter := rl.allow(ip)
-			if !allowed {
-				Error_msg := fmt.Sprintf("Too many requests. Try again in %s", formatRetryString(retryAfter))
-				http.Error(w, Error_msg, http.StatusTooManyRequests)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
+	return rateLimit(rl, ClientIP)
 }
 
 func formatRetryString(window time.Duration) string {
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestLogger gives each request a context that handlers can attach log
+// fields to, starting with its request ID, and logs one record per request
+// once it completes. It should run after RequestID.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := utils.WithLogFields(r.Context(), slog.String("request_id", middleware.GetReqID(r.Context())))
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// LogIdentity adds the caller's user ID to the request's log fields. It
+// should run after auth.Middleware.
+func LogIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := auth.FromContext(r.Context()); ok {
+			utils.AddLogFields(r.Context(), slog.Int64("user_id", id.UserID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
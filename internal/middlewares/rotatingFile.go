@@ -0,0 +1,219 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotationTimeFormat names rotated files so they sort oldest first.
+const rotationTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotationOptions control when a log file is rotated. A zero MaxSize or
+// MaxAge disables that trigger.
+type RotationOptions struct {
+	MaxSize int64
+	MaxAge  time.Duration
+	// MaxBackups rotated files are kept, newest first; 0 keeps all of them.
+	MaxBackups int
+	// Compress gzips rotated files in the background.
+	Compress bool
+}
+
+// rotatingFile is an io.Writer that moves its file aside to
+// <path>.<timestamp> once it grows past MaxSize or gets older than MaxAge.
+// It is only written to by the access log's writer goroutine, but Close may
+// come from elsewhere.
+type rotatingFile struct {
+	path     string
+	opts     RotationOptions
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	// compressing tracks background gzip jobs so Close can wait for them.
+	compressing sync.WaitGroup
+	rotations   uint64
+}
+
+func openRotatingFile(path string, opts RotationOptions) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, opts: opts}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Error opening log file: %w", err)
+	}
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.dueForRotation(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			// Keep logging into the current file rather than lose entries.
+			slog.Error("access log rotation failed", "path", rf.path, "err", err)
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) dueForRotation(next int64) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.opts.MaxSize > 0 && rf.size+next > rf.opts.MaxSize {
+		return true
+	}
+	return rf.opts.MaxAge > 0 && time.Since(rf.openedAt) >= rf.opts.MaxAge
+}
+
+func (rf *rotatingFile) rotate() error {
+	rotated := rf.backupName(time.Now())
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	if err := os.Rename(rf.path, rotated); err != nil {
+		if openErr := rf.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.rotations++
+
+	if rf.opts.Compress {
+		rf.compressing.Add(1)
+		go func() {
+			defer rf.compressing.Done()
+			if err := compressFile(rotated); err != nil {
+				slog.Error("access log compression failed", "path", rotated, "err", err)
+			}
+			rf.prune()
+		}()
+	} else {
+		rf.prune()
+	}
+	return nil
+}
+
+// backupName picks an unused name for a file rotated at t; two rotations in
+// the same millisecond get a counter.
+func (rf *rotatingFile) backupName(t time.Time) string {
+	base := rf.path + "." + t.Format(rotationTimeFormat)
+	name := base
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	return name
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compressFile replaces path with path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune removes the oldest rotated files beyond MaxBackups.
+func (rf *rotatingFile) prune() {
+	if rf.opts.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, m := range matches {
+		// A file being compressed exists twice; count it once, as the .gz.
+		if !strings.HasSuffix(m, ".gz") && exists(m+".gz") {
+			continue
+		}
+		backups = append(backups, m)
+	}
+	if len(backups) <= rf.opts.MaxBackups {
+		return
+	}
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-rf.opts.MaxBackups] {
+		if err := os.Remove(old); err != nil {
+			slog.Error("removing old access log failed", "path", old, "err", err)
+		}
+	}
+}
+
+func (rf *rotatingFile) Rotations() uint64 {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotations
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	var err error
+	if rf.file != nil {
+		err = rf.file.Close()
+		rf.file = nil
+	}
+	rf.mu.Unlock()
+	rf.compressing.Wait()
+	return err
+}
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+// hotKeysHandler lists the most requested codes with their estimated redirect
+// counts, hottest first; ?limit= caps the list.
+func hotKeysHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		keys, err := handlers.HotKeys(r.Context(), DB, limit)
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to fetch hot keys"))
+			return
+		}
+		writeJSON(w, http.StatusOK, keys)
+	}
+}
+
+// rateLimitStatsHandler reports how often limiting fell back to its failure
+// mode because Redis was unavailable.
+func rateLimitStatsHandler(limits *middlewares.RateLimiters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, limits.Stats())
+	}
+}
@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+)
+
+type Credentials struct {
+	Username string `param:"username" query:"username" header:"username" json:"username" xml:"username" form:"username"`
+	Password string `param:"password" query:"password" header:"password" json:"password" xml:"password" form:"password"`
+	KeyName  string `param:"name" query:"name" header:"name" json:"name,omitempty" xml:"name,omitempty" form:"name"`
+}
+
+func registerHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input Credentials
+		if err := parseRequest(r, &input); err != nil {
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
+			return
+		}
+		userID, key, err := handlers.RegisterUser(r.Context(), DB, input.Username, input.Password)
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to register"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"user_id": userID, "api_key": key})
+	}
+}
+
+func issueKeyHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input Credentials
+		if err := parseRequest(r, &input); err != nil {
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
+			return
+		}
+		key, err := handlers.IssueAPIKey(r.Context(), DB, input.Username, input.Password, input.KeyName)
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to create API key"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"api_key": key})
+	}
+}
+
+// ownerFromRequest returns the caller's user ID, nil for anonymous requests.
+func ownerFromRequest(r *http.Request) *int64 {
+	id, ok := auth.FromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	return &id.UserID
+}
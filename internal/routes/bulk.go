@@ -0,0 +1,165 @@
+package routes
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+const maxBulkBodySize = 10 << 20
+
+type bulkResponse struct {
+	Created int                   `json:"created"`
+	Failed  int                   `json:"failed"`
+	Results []handlers.BulkResult `json:"results"`
+}
+
+// bulkCreateHandler accepts a JSON array of items, a text/csv body or a
+// multipart upload with the CSV in the "file" field.
+func bulkCreateHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBulkBodySize)
+
+		items, err := parseBulkRequest(r)
+		if err != nil {
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
+			return
+		}
+		if len(items) == 0 {
+			customerrors.Write(w, r, customerrors.Invalidf("no links to create"))
+			return
+		}
+		if len(items) > handlers.MaxBulkItems {
+			customerrors.Write(w, r, customerrors.Newf(customerrors.KindTooLarge, "too many links, the limit is %d", handlers.MaxBulkItems))
+			return
+		}
+
+		// Charge for the whole batch up front so a batch can't overshoot the
+		// quota, then refund what wasn't created.
+		if !consumeQuota(w, r, DB, int64(len(items))) {
+			return
+		}
+		results, err := handlers.CreateShortURLsBulk(r.Context(), DB, items, handlers.CreateOptions{
+			Strategy: r.URL.Query().Get("strategy"),
+			OwnerID:  ownerFromRequest(r),
+		})
+		if err != nil {
+			releaseQuota(r, DB, int64(len(items)))
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to create links"))
+			return
+		}
+		resp := bulkResponse{Results: results}
+		for _, res := range results {
+			if res.Error == "" {
+				resp.Created++
+			} else {
+				resp.Failed++
+			}
+		}
+		releaseQuota(r, DB, int64(resp.Failed))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func parseBulkRequest(r *http.Request) ([]handlers.BulkItem, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("missing or invalid content type")
+	}
+
+	switch mediaType {
+	case "application/json":
+		var raw []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			return nil, err
+		}
+		items := make([]handlers.BulkItem, len(raw))
+		for i, entry := range raw {
+			if err := json.Unmarshal(entry, &items[i]); err != nil {
+				items[i] = handlers.BulkItem{Invalid: fmt.Sprintf("invalid item: %v", err)}
+			}
+		}
+		return items, nil
+	case "text/csv":
+		return parseBulkCSV(r.Body)
+	case "multipart/form-data":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("missing CSV file: %w", err)
+		}
+		defer file.Close()
+		return parseBulkCSV(file)
+	}
+	return nil, fmt.Errorf("unsupported content type: %s", mediaType)
+}
+
+// parseBulkCSV reads a CSV with a header row naming the long_url, alias,
+// expires_at (RFC 3339) and tags ("|" separated) columns; only long_url is
+// required.
+func parseBulkCSV(body io.Reader) ([]handlers.BulkItem, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["long_url"]; !ok {
+		return nil, errors.New("CSV header has no long_url column")
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var items []handlers.BulkItem
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV line %d: %w", line, err)
+		}
+		if len(items) == handlers.MaxBulkItems {
+			return nil, customerrors.Newf(customerrors.KindTooLarge, "too many links, the limit is %d", handlers.MaxBulkItems)
+		}
+		item := handlers.BulkItem{
+			LongURL: field(record, "long_url"),
+			Alias:   field(record, "alias"),
+		}
+		if raw := field(record, "expires_at"); raw != "" {
+			expiresAt, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				item.Invalid = fmt.Sprintf("invalid expires_at %q", raw)
+			} else {
+				item.ExpiresAt = &expiresAt
+			}
+		}
+		if raw := field(record, "tags"); raw != "" {
+			item.Tags = strings.Split(raw, "|")
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/export"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+// exportHandler streams all links as csv, ndjson or parquet (the format query
+// parameter); stats=true adds click counts.
+func exportHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = export.FormatCSV
+		}
+		if format != export.FormatCSV && format != export.FormatNDJSON && format != export.FormatParquet {
+			customerrors.Write(w, r, customerrors.Invalidf("unknown export format %q", format))
+			return
+		}
+		withClicks, _ := strconv.ParseBool(r.URL.Query().Get("stats"))
+
+		// Large exports outlive the server's write timeout.
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		filename := fmt.Sprintf("links-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+		w.Header().Set("Content-Type", export.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+
+		err := handlers.ExportLinks(r.Context(), DB, &flushWriter{w: w}, format, withClicks)
+		if err != nil {
+			// Headers are gone already; cutting the stream short is the only
+			// signal left to the client.
+			slog.ErrorContext(r.Context(), "export failed after streaming began", "err", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// flushWriter pushes every chunk the encoder emits to the client.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	err = http.NewResponseController(fw.w).Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return n, err
+	}
+	return n, nil
+}
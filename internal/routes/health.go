@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	"github.com/go-chi/chi/v5"
+)
+
+// probeTimeout bounds the dependency pings behind /readyz and /status, so a
+// hung dependency fails the probe instead of stalling it.
+const probeTimeout = 2 * time.Second
+
+// HealthRoutes adds /healthz, /readyz and /status to router. They sit outside
+// /api so rate limits and authentication never apply to them.
+func HealthRoutes(router chi.Router, health *handlers.Health) {
+	router.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	router.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+		report := health.Ready(ctx)
+		writeJSON(w, readyStatus(report.Ready), report)
+	})
+	router.Get("/status", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+		report := health.Status(ctx)
+		writeJSON(w, readyStatus(report.Ready), report)
+	})
+}
+
+func readyStatus(ready bool) int {
+	if ready {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
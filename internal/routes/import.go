@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxImportBodySize = 64 << 20
+
+// importHandler queues an export from another shortener. The source query
+// parameter picks the format (bitly, yourls or csv); the file is either the
+// raw body or the "file" field of a multipart upload. Uploads wait in the
+// queue as temporary files, so queued jobs don't hold their data in memory.
+func importHandler(importer *handlers.Importer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Query().Get("source")
+		if source == "" {
+			source = handlers.ImportSourceGeneric
+		}
+		if !handlers.ValidImportSource(source) {
+			customerrors.Write(w, r, customerrors.Invalidf("unknown import source %q", source))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportBodySize)
+		path, err := spoolImportBody(r)
+		if err != nil {
+			customerrors.Write(w, r, err)
+			return
+		}
+
+		// Only signed-in callers reach here; see SetupRoutes.
+		caller, _ := auth.FromContext(r.Context())
+		id, err := importer.Submit(r.Context(), source, path, caller)
+		if err != nil {
+			os.Remove(path)
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to queue import"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", fmt.Sprintf("/api/jobs/%d", id))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]int64{"job_id": id})
+	}
+}
+
+// spoolImportBody copies the upload to a temporary file and returns its
+// path. The caller removes the file once it is no longer needed.
+func spoolImportBody(r *http.Request) (string, error) {
+	body := io.Reader(r.Body)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return "", customerrors.InvalidRequest(fmt.Errorf("missing file: %w", err))
+		}
+		defer file.Close()
+		body = file
+	}
+
+	file, err := os.CreateTemp("", "import-*.csv")
+	if err != nil {
+		return "", customerrors.Internal(err, "failed to store upload")
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		os.Remove(file.Name())
+		return "", customerrors.Internal(closeErr, "failed to store upload")
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", customerrors.InvalidRequest(err)
+	}
+	return file.Name(), nil
+}
+
+func jobStatusHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Invalidf("invalid job ID"))
+			return
+		}
+		job, err := handlers.JobStatus(DB, id, userID(r))
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to fetch job"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(job)
+	}
+}
@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+	"github.com/go-chi/chi/v5"
+)
+
+type EditLink struct {
+	LongURL string    `json:"long_url,omitempty"`
+	Tags    *[]string `json:"tags,omitempty"`
+	// Folder moves the link; "" takes it out of its folder.
+	Folder *string `json:"folder,omitempty"`
+}
+
+type TagRename struct {
+	Name string `json:"name"`
+}
+
+type TagMerge struct {
+	Sources []string `json:"sources"`
+	Target  string   `json:"target"`
+}
+
+type FolderName struct {
+	Name string `json:"name"`
+}
+
+func userID(r *http.Request) int64 {
+	id, _ := auth.FromContext(r.Context())
+	return id.UserID
+}
+
+// listLinksHandler pages through the caller's links, newest first, with
+// optional ?tag=, ?folder=, ?limit= and the ?cursor= from the previous page.
+func listLinksHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		cursor, _ := strconv.ParseInt(query.Get("cursor"), 10, 64)
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		page, err := handlers.ListLinks(r.Context(), DB, userID(r), query.Get("tag"), query.Get("folder"), cursor, limit)
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to list links"))
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+func editLinkHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input EditLink
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
+			return
+		}
+		var tags []string
+		if input.Tags != nil {
+			tags = append([]string{}, *input.Tags...)
+		}
+		short := chi.URLParam(r, "id")
+		utils.AddLogFields(r.Context(), slog.String("short_code", short))
+		err := handlers.EditLink(r.Context(), DB, userID(r), short, input.LongURL, tags, input.Folder)
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to edit link"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func tagStatsHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := handlers.TagStats(r.Context(), DB, userID(r))
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to fetch tags"))
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
+
+func renameTagHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input TagRename
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
+			return
+		}
+		err := handlers.RenameTag(r.Context(), DB, userID(r), chi.URLParam(r, "name"), input.Name)
+		writeNoContent(w, r, err, "failed to rename tag")
+	}
+}
+
+func mergeTagsHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input TagMerge
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
+			return
+		}
+		err := handlers.MergeTags(r.Context(), DB, userID(r), input.Sources, input.Target)
+		writeNoContent(w, r, err, "failed to merge tags")
+	}
+}
+
+// writeNoContent answers 204 for updates that return nothing, or reports err;
+// message describes untyped errors to the client.
+func writeNoContent(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if err != nil {
+		customerrors.Write(w, r, customerrors.Internal(err, message))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listFoldersHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		folders, err := handlers.Folders(r.Context(), DB, userID(r))
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to list folders"))
+			return
+		}
+		writeJSON(w, http.StatusOK, folders)
+	}
+}
+
+func createFolderHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input FolderName
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
+			return
+		}
+		if err := handlers.CreateFolder(r.Context(), DB, userID(r), input.Name); err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to create folder"))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func renameFolderHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input FolderName
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
+			return
+		}
+		err := handlers.RenameFolder(r.Context(), DB, userID(r), chi.URLParam(r, "name"), input.Name)
+		writeNoContent(w, r, err, "failed to rename folder")
+	}
+}
+
+// deleteFolderHandler removes a folder; its links stay, unfiled.
+func deleteFolderHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := handlers.DeleteFolder(r.Context(), DB, userID(r), chi.URLParam(r, "name"))
+		writeNoContent(w, r, err, "failed to delete folder")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"log/slog"
+	"net/http"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+)
+
+// consumeQuota charges n links to the caller's plan, or to the free quota of
+// their IP address when they have no API key. If the request can't go ahead
+// it writes the response and returns false; see handlers.QuotaExceeded for
+// the statuses.
+func consumeQuota(w http.ResponseWriter, r *http.Request, DB *Storage.URLDB, n int64) bool {
+	var err error
+	if id, ok := auth.FromContext(r.Context()); ok {
+		err = handlers.ConsumeQuota(r.Context(), DB, id, n)
+	} else {
+		err = handlers.ConsumeAnonymousQuota(r.Context(), DB, middlewares.ClientIP(r), n)
+	}
+	if err != nil {
+		customerrors.Write(w, r, customerrors.Internal(err, "failed to check quota"))
+		return false
+	}
+	return true
+}
+
+// releaseQuota refunds links charged by consumeQuota that weren't created.
+func releaseQuota(r *http.Request, DB *Storage.URLDB, n int64) {
+	var err error
+	if id, ok := auth.FromContext(r.Context()); ok {
+		err = handlers.ReleaseQuota(r.Context(), DB, id, n)
+	} else {
+		err = handlers.ReleaseAnonymousQuota(r.Context(), DB, middlewares.ClientIP(r), n)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "releasing quota failed", "err", err)
+	}
+}
+
+func usageHandler(DB *Storage.URLDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usage, err := handlers.GetUsage(r.Context(), DB, userID(r))
+		if err != nil {
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to fetch usage"))
+			return
+		}
+		writeJSON(w, http.StatusOK, usage)
+	}
+}
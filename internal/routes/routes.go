@@ -4,104 +4,123 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strings"
 
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
 	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
 	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
 	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
 	"github.com/go-chi/chi/v5"
 )
 
 type Create struct {
-	LongURL string `param:"long_url" query:"long_url" header:"long_url" json:"long_url" xml:"long_url" form:"long_url"`
+	LongURL  string   `param:"long_url" query:"long_url" header:"long_url" json:"long_url" xml:"long_url" form:"long_url"`
+	Strategy string   `param:"strategy" query:"strategy" header:"strategy" json:"strategy,omitempty" xml:"strategy,omitempty" form:"strategy"`
+	Tags     []string `param:"tags" query:"tags" header:"tags" json:"tags,omitempty" xml:"tags>tag,omitempty" form:"tags"`
 }
 
-func SetupRoutes(DB *Storage.URLDB, postlimiter, getlimiter *middlewares.Ratelimiter) *chi.Mux {
+func SetupRoutes(DB *Storage.URLDB, importer *handlers.Importer, adminToken string, limits *middlewares.RateLimiters) *chi.Mux {
 	router := chi.NewRouter()
-	// router.With(middlewares.RateLimitMiddleware(getlimiter)).Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
-	// 	id := chi.URLParam(r, "id")
-	// 	if id == "" {
-	// 		http.Error(w, "Missing URL ID", http.StatusBadRequest)
-	// 		return
-	// 	}
-	// 	url, err := handlers.GetLongURL(DB, id)
-	// 	if err != nil {
-	// 		http.Error(w, "URL not found", http.StatusNotFound)
-	// 		return
-	// 	}
-	// 	w.Header().Set("Content-Type", "application/json")
-	// 	w.WriteHeader(http.StatusOK)
-	// 	json.NewEncoder(w).Encode(url)
-	// })
-	router.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+	router.NotFound(customerrors.NotFoundHandler)
+	router.MethodNotAllowed(customerrors.MethodNotAllowedHandler)
+	router.Use(withAPIKey(limits.Middleware("api_key")))
+	router.Use(auth.Middleware(handlers.IdentityResolver(DB)))
+	router.Use(middlewares.LogIdentity)
+	router.With(limits.Middleware("redirect")).Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 		if id == "" {
-			http.Error(w, "Missing URL ID", http.StatusBadRequest)
+			customerrors.Write(w, r, customerrors.Invalidf("missing URL ID"))
 			return
 		}
-		url, err := handlers.GetLongURL(DB, id)
+		utils.AddLogFields(r.Context(), slog.String("short_code", id))
+		url, err := handlers.GetLongURL(r.Context(), DB, id)
 		if err != nil {
-			http.Error(w, "URL not found", http.StatusNotFound)
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to resolve URL"))
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(url)
 	})
-	router.Post("/create", func(w http.ResponseWriter, r *http.Request) {
+	router.With(limits.Middleware("create")).Post("/create", func(w http.ResponseWriter, r *http.Request) {
 		var input Create
 
 		err := parseRequest(r, &input)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			customerrors.Write(w, r, customerrors.InvalidRequest(err))
 			return
 		}
 
 		if input.LongURL == "" {
-			http.Error(w, "Missing long_url parameter", http.StatusBadRequest)
+			customerrors.Write(w, r, customerrors.Invalidf("missing long_url parameter"))
 			return
 		}
 
-		shorturl, err := handlers.CreateShortURL(DB, input.LongURL)
+		if !consumeQuota(w, r, DB, 1) {
+			return
+		}
+		shorturl, err := handlers.CreateShortURL(r.Context(), DB, input.LongURL, handlers.CreateOptions{
+			Strategy: input.Strategy,
+			Tags:     input.Tags,
+			OwnerID:  ownerFromRequest(r),
+		})
 		if err != nil {
-			fmt.Println(err)
-			http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
+			releaseQuota(r, DB, 1)
+			customerrors.Write(w, r, customerrors.Internal(err, "failed to create short URL"))
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(shorturl)
 	})
-	// router.With(middlewares.RateLimitMiddleware(postlimiter)).Post("/create", func(w http.ResponseWriter, r *http.Request) {
-	// 	var input Create
-	//
-	// 	err := parseRequest(r, &input)
-	// 	if err != nil {
-	// 		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
-	// 		return
-	// 	}
-	//
-	// 	if input.LongURL == "" {
-	// 		http.Error(w, "Missing long_url parameter", http.StatusBadRequest)
-	// 		return
-	// 	}
-	//
-	// 	shorturl, err := handlers.CreateShortURL(DB, input.LongURL)
-	// 	if err != nil {
-	// 		fmt.Println(err)
-	// 		http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusInternalServerError)
-	// 		return
-	// 	}
-	// 	w.Header().Set("Content-Type", "application/json")
-	// 	w.WriteHeader(http.StatusCreated)
-	// 	json.NewEncoder(w).Encode(shorturl)
-	// })
+	router.With(limits.Middleware("create")).Post("/links/bulk", bulkCreateHandler(DB))
+	router.With(limits.Middleware("auth")).Post("/auth/register", registerHandler(DB))
+	router.With(limits.Middleware("auth")).Post("/auth/keys", issueKeyHandler(DB))
+	router.Group(func(router chi.Router) {
+		router.Use(auth.RequireIdentity)
+		router.Get("/me/usage", usageHandler(DB))
+		router.With(limits.Middleware("create")).Post("/imports", importHandler(importer))
+		router.Get("/jobs/{id}", jobStatusHandler(DB))
+		router.Get("/links", listLinksHandler(DB))
+		router.Patch("/links/{id}", editLinkHandler(DB))
+		router.Get("/tags", tagStatsHandler(DB))
+		router.Patch("/tags/{name}", renameTagHandler(DB))
+		router.Post("/tags/merge", mergeTagsHandler(DB))
+		router.Get("/folders", listFoldersHandler(DB))
+		router.Post("/folders", createFolderHandler(DB))
+		router.Patch("/folders/{name}", renameFolderHandler(DB))
+		router.Delete("/folders/{name}", deleteFolderHandler(DB))
+	})
+	router.Group(func(router chi.Router) {
+		router.Use(auth.RequireAdminToken(adminToken))
+		// The export holds every owner's links, so it is for operators only.
+		router.Get("/admin/export", exportHandler(DB))
+		router.Get("/admin/hot-keys", hotKeysHandler(DB))
+		router.Get("/admin/rate-limits", rateLimitStatsHandler(limits))
+	})
 	return router
 }
 
+// withAPIKey applies mw only to requests that carry an API key.
+func withAPIKey(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if auth.APIKeyFromRequest(r) == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
+
 func parseRequest(r *http.Request, target any) error {
 	contentType := r.Header.Get("Content-Type")
 
@@ -141,10 +160,7 @@ func formToStruct(form url.Values, target any) error {
 		}
 
 		if values, ok := form[tag]; ok && len(values) > 0 {
-			fieldValue := val.Field(i)
-			if fieldValue.CanSet() {
-				fieldValue.SetString(values[0])
-			}
+			setField(val.Field(i), values)
 		}
 	}
 	return nil
@@ -162,11 +178,26 @@ func headerToStruct(header http.Header, target any) error {
 		}
 
 		if values, ok := header[tag]; ok && len(values) > 0 {
-			fieldValue := val.Field(i)
-			if fieldValue.CanSet() {
-				fieldValue.SetString(values[0])
-			}
+			setField(val.Field(i), values)
 		}
 	}
 	return nil
 }
+
+// setField assigns the first value to string fields; string slices take every
+// value, each split on commas.
+func setField(fieldValue reflect.Value, values []string) {
+	if !fieldValue.CanSet() {
+		return
+	}
+	switch {
+	case fieldValue.Kind() == reflect.String:
+		fieldValue.SetString(values[0])
+	case fieldValue.Kind() == reflect.Slice && fieldValue.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, v := range values {
+			items = append(items, strings.Split(v, ",")...)
+		}
+		fieldValue.Set(reflect.ValueOf(items))
+	}
+}
@@ -1,83 +1,250 @@
 package Storage
 
 import (
+	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// entryOverhead approximates the bookkeeping bytes of one entry (list
+// element, map slot, struct) on top of its key and value.
+const entryOverhead = 96
+
+type CacheOptions struct {
+	// MaxEntries and MaxBytes bound the whole cache; 0 disables a limit.
+	MaxEntries int
+	MaxBytes   int64
+	// Shards is rounded up to a power of two.
+	Shards          int
+	CleanupInterval time.Duration
+}
+
+var DefaultCacheOptions = CacheOptions{
+	MaxEntries:      100000,
+	MaxBytes:        64 << 20,
+	Shards:          64,
+	CleanupInterval: 30 * time.Second,
+}
+
+type CacheStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+}
+
 type cacheItem struct {
+	key    string
 	value  string
 	expiry time.Time
 }
 
-type cache struct {
-	data        map[string]cacheItem
-	mu          sync.RWMutex
+func (item *cacheItem) size() int64 {
+	return int64(len(item.key) + len(item.value) + entryOverhead)
+}
+
+// cacheShard is an LRU list plus an index into it. Reads move entries to the
+// front, so even Get takes the write lock; sharding keeps that cheap.
+type cacheShard struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+}
+
+// Cache is a bounded, sharded LRU cache with per-entry expiry.
+type Cache struct {
+	shards      []*cacheShard
+	mask        uint32
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
 	stopCleanup chan struct{}
+	stopOnce    sync.Once
 }
 
-func newCache() *cache {
-	c := &cache{
-		data:        make(map[string]cacheItem),
-		mu:          sync.RWMutex{},
+func NewCache(opts CacheOptions) *Cache {
+	n := 1
+	for n < opts.Shards {
+		n <<= 1
+	}
+	c := &Cache{
+		shards:      make([]*cacheShard, n),
+		mask:        uint32(n - 1),
 		stopCleanup: make(chan struct{}),
 	}
-	go c.startCleanup(30 * time.Second)
+	for i := range c.shards {
+		shard := &cacheShard{
+			items: make(map[string]*list.Element),
+			lru:   list.New(),
+		}
+		if opts.MaxEntries > 0 {
+			shard.maxEntries = max(1, opts.MaxEntries/n)
+		}
+		if opts.MaxBytes > 0 {
+			shard.maxBytes = max(1, opts.MaxBytes/int64(n))
+		}
+		c.shards[i] = shard
+	}
+	if opts.CleanupInterval > 0 {
+		go c.startCleanup(opts.CleanupInterval)
+	}
 	return c
 }
 
-func (c *cache) Set(key string, value string, ttl time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *Cache) shard(key string) *cacheShard {
+	// FNV-1a, inlined to avoid allocating a hash.Hash per call.
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h&c.mask]
+}
+
+func (c *Cache) Set(key string, value string, ttl time.Duration) {
+	item := &cacheItem{key: key, value: value, expiry: time.Now().Add(ttl)}
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	c.data[key] = cacheItem{
-		value:  value,
-		expiry: time.Now().Add(ttl),
+	if s.maxBytes > 0 && item.size() > s.maxBytes {
+		// Too big to cache, but the old value must not outlive the update.
+		if el, ok := s.items[key]; ok {
+			s.removeElement(el)
+		}
+		return
+	}
+
+	if el, ok := s.items[key]; ok {
+		old := el.Value.(*cacheItem)
+		s.bytes += item.size() - old.size()
+		el.Value = item
+		s.lru.MoveToFront(el)
+	} else {
+		s.items[key] = s.lru.PushFront(item)
+		s.bytes += item.size()
+	}
+	for (s.maxEntries > 0 && s.lru.Len() > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes) {
+		s.removeElement(s.lru.Back())
+		c.evictions.Add(1)
 	}
 }
 
-func (c *cache) Get(key string) (string, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+func (c *Cache) Get(key string) (string, bool) {
+	value, _, ok := c.GetWithExpiry(key)
+	return value, ok
+}
 
-	item, ok := c.data[key]
+// GetWithExpiry is Get that also returns when the entry expires.
+func (c *Cache) GetWithExpiry(key string) (string, time.Time, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
 	if !ok {
-		return "", false
+		c.misses.Add(1)
+		return "", time.Time{}, false
+	}
+	item := el.Value.(*cacheItem)
+	if !time.Now().Before(item.expiry) {
+		s.removeElement(el)
+		c.expirations.Add(1)
+		c.misses.Add(1)
+		return "", time.Time{}, false
+	}
+	s.lru.MoveToFront(el)
+	c.hits.Add(1)
+	return item.value, item.expiry, true
+}
+
+func (c *Cache) Delete(key string) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.removeElement(el)
 	}
+}
 
-	return item.value, true
+// Purge drops every entry.
+func (c *Cache) Purge() {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = make(map[string]*list.Element)
+		s.lru.Init()
+		s.bytes = 0
+		s.mu.Unlock()
+	}
 }
 
-func (c *cache) Delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// Len returns the number of entries, including expired ones not yet swept.
+func (c *Cache) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
 
-	delete(c.data, key)
+func (c *Cache) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+	}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		stats.Entries += s.lru.Len()
+		stats.Bytes += s.bytes
+		s.mu.Unlock()
+	}
+	return stats
 }
 
-func (c *cache) Stop() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *Cache) Stop() {
+	c.stopOnce.Do(func() {
+		c.Purge()
+		close(c.stopCleanup)
+	})
+}
 
-	c.data = make(map[string]cacheItem)
-	close(c.stopCleanup)
+func (s *cacheShard) removeElement(el *list.Element) {
+	item := el.Value.(*cacheItem)
+	s.lru.Remove(el)
+	delete(s.items, item.key)
+	s.bytes -= item.size()
 }
 
-func (c *cache) startCleanup(interval time.Duration) {
+func (c *Cache) startCleanup(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			c.mu.Lock()
 			now := time.Now()
-			for k, v := range c.data {
-				if v.expiry.Before(now) {
-					delete(c.data, k)
+			for _, s := range c.shards {
+				s.mu.Lock()
+				for _, el := range s.items {
+					if !now.Before(el.Value.(*cacheItem).expiry) {
+						s.removeElement(el)
+						c.expirations.Add(1)
+					}
 				}
+				s.mu.Unlock()
 			}
-			c.mu.Unlock()
 
 		case <-c.stopCleanup:
 			return
@@ -2,34 +2,74 @@ package Storage
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/tracing"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
+var tracer = tracing.Tracer()
+
 type URLDB struct {
 	DB          *pgxpool.Pool
 	Redis       *redis.Client
-	Cache       *cache
+	Cache       *Cache
 	Ctx         context.Context
 	Mut         sync.Mutex
 	Wg          sync.WaitGroup
-	insertQueue chan urlpair
+	insertQueue chan NewURL
+	// queueMu guards closing, so SaveURL never sends on the closed queue.
+	queueMu       sync.RWMutex
+	closing       bool
+	abandon       atomic.Bool
+	workerCtx     context.Context
+	cancelWorkers context.CancelFunc
+	insertStats   insertStats
+	clicks        *clickCounter
+	bus           *invalidationBus
+	negative      *Cache
+	filter        *codeFilter
+	lookups       singleflight.Group
+	lookupStats   lookupStats
+	ttls          atomic.Pointer[CacheTTLs]
+	source        LinkSource
 }
-type urlpair struct {
-	short string
-	long  string
+
+type Options struct {
+	Cache           CacheOptions
+	TTLs            CacheTTLs
+	InsertWorkers   int
+	InsertQueueSize int
 }
 
-func ConnectToDB(pgconn string, redisAddr string) (*URLDB, error) {
+var DefaultOptions = Options{
+	Cache:           DefaultCacheOptions,
+	TTLs:            DefaultCacheTTLs,
+	InsertWorkers:   5,
+	InsertQueueSize: 200,
+}
+
+func ConnectToDB(pgconn string, redisAddr string, opts Options) (*URLDB, error) {
 	ctx := context.Background()
 
-	db, err := pgxpool.New(ctx, pgconn)
+	poolConfig, err := pgxpool.ParseConfig(pgconn)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite error: %w", err)
+	}
+	poolConfig.ConnConfig.Tracer = tracing.PgxTracer{}
+	db, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("sqlite error: %w", err)
 	}
@@ -43,44 +83,172 @@ func ConnectToDB(pgconn string, redisAddr string) (*URLDB, error) {
 		Addr: redisAddr,
 		DB:   0,
 	})
+	if err := tracing.InstrumentRedis(rdb); err != nil {
+		return nil, fmt.Errorf("Redis tracing error: %w", err)
+	}
 
 	_, err = rdb.Ping(context.Background()).Result()
 	if err != nil {
 		return nil, fmt.Errorf("Redis connection error: %w", err)
 	}
 
-	cache := newCache()
+	URLDB := NewURLDB(rdb, postgresSource{db: db}, opts)
+	URLDB.DB = db
+	return URLDB, nil
+}
+
+// NewURLDB sets up the caches, the invalidation bus and the background
+// workers around an open Redis client, reading links that miss the caches
+// from source. Only the lookup path works without DB.
+func NewURLDB(rdb *redis.Client, source LinkSource, opts Options) *URLDB {
+	cache := NewCache(opts.Cache)
 
 	URLDB := &URLDB{
-		DB:          db,
 		Redis:       rdb,
 		Cache:       cache,
-		Ctx:         ctx,
+		Ctx:         context.Background(),
 		Mut:         sync.Mutex{},
 		Wg:          sync.WaitGroup{},
-		insertQueue: make(chan urlpair, 200),
+		insertQueue: make(chan NewURL, opts.InsertQueueSize),
+		source:      source,
 	}
 
-	URLDB.startInsertWorkers(5)
+	URLDB.SetCacheTTLs(opts.TTLs)
+	URLDB.startInsertWorkers(opts.InsertWorkers)
+	URLDB.clicks = newClickCounter(URLDB, 10*time.Second)
+	URLDB.negative = NewCache(CacheOptions{
+		MaxEntries:      negativeCacheEntries,
+		Shards:          16,
+		CleanupInterval: time.Minute,
+	})
+	// The filter is loaded once the bus first connects, so that no create
+	// announced from then on can be missed.
+	URLDB.filter = newCodeFilter(source.CountCodes, source.ScanCodes)
+	URLDB.bus = newInvalidationBus(rdb, cache, URLDB.negative, URLDB.filter)
 
-	return URLDB, nil
+	return URLDB
 }
 
-func (URLDB *URLDB) Close() error {
-	close(URLDB.insertQueue)
+// ErrShuttingDown is returned by SaveURL once Shutdown has started.
+var ErrShuttingDown = customerrors.Unavailable("storage is shutting down")
+
+// ErrCodeTaken is returned when a link's short code already belongs to
+// another link, expired ones included.
+var ErrCodeTaken = customerrors.Conflict("short code is already taken")
+
+// ErrQueueFull is returned by SaveURL while the insert workers are behind.
+var ErrQueueFull = &customerrors.Error{
+	Kind:       customerrors.KindUnavailable,
+	Message:    "too many links are being created, try again shortly",
+	RetryAfter: time.Second,
+}
+
+// DrainReport says what happened to the links still queued for insertion
+// when Shutdown started.
+type DrainReport struct {
+	Pending int
+	Flushed uint64
+	Failed  uint64
+	// Dropped links were abandoned when ctx expired; they were served from
+	// the caches but never reached Postgres.
+	Dropped uint64
+}
+
+type insertStats struct {
+	flushed atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+	retries atomic.Uint64
+}
+
+// InsertStats describes the insert queue and what the workers have done with
+// it since the start.
+type InsertStats struct {
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	Inserted uint64 `json:"inserted"`
+	Retries  uint64 `json:"retries"`
+	Failed   uint64 `json:"failed"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+func (URLDB *URLDB) InsertStats() InsertStats {
+	return InsertStats{
+		Depth:    len(URLDB.insertQueue),
+		Capacity: cap(URLDB.insertQueue),
+		Inserted: URLDB.insertStats.flushed.Load(),
+		Retries:  URLDB.insertStats.retries.Load(),
+		Failed:   URLDB.insertStats.failed.Load(),
+		Dropped:  URLDB.insertStats.dropped.Load(),
+	}
+}
+
+// Shutdown stops accepting links, waits for the insert workers to store the
+// queued ones until ctx is done, flushes click counts and closes every
+// connection.
+func (URLDB *URLDB) Shutdown(ctx context.Context) (DrainReport, error) {
+	report := URLDB.DrainInserts(ctx)
+
+	URLDB.clicks.Stop()
 
-	URLDB.Wg.Wait()
+	URLDB.bus.Stop()
 
-	URLDB.DB.Close()
+	URLDB.filter.Stop()
+
+	if URLDB.DB != nil {
+		URLDB.DB.Close()
+	}
 
 	URLDB.Cache.Stop()
 
+	URLDB.negative.Stop()
+
 	err := URLDB.Redis.Close()
 	if err != nil {
-		return err
+		return report, err
 	}
 
-	return nil
+	return report, nil
+}
+
+func (URLDB *URLDB) Close() error {
+	_, err := URLDB.Shutdown(context.Background())
+	return err
+}
+
+// DrainInserts stops accepting links and waits for the queued ones to be
+// stored until ctx is done. Only the first call drains; Shutdown calls it too.
+func (URLDB *URLDB) DrainInserts(ctx context.Context) DrainReport {
+	URLDB.queueMu.Lock()
+	if URLDB.closing {
+		URLDB.queueMu.Unlock()
+		return DrainReport{}
+	}
+	URLDB.closing = true
+	report := DrainReport{Pending: len(URLDB.insertQueue)}
+	flushed, failed, dropped := URLDB.insertStats.flushed.Load(), URLDB.insertStats.failed.Load(), URLDB.insertStats.dropped.Load()
+	close(URLDB.insertQueue)
+	URLDB.queueMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		URLDB.Wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		// Skip what is still queued and cut short the inserts in flight.
+		URLDB.abandon.Store(true)
+		URLDB.cancelWorkers()
+		<-drained
+	}
+	URLDB.cancelWorkers()
+
+	report.Flushed = URLDB.insertStats.flushed.Load() - flushed
+	report.Failed = URLDB.insertStats.failed.Load() - failed
+	report.Dropped = URLDB.insertStats.dropped.Load() - dropped
+	return report
 }
 
 func (URLDB *URLDB) createURLtable() error {
@@ -110,6 +278,55 @@ func (URLDB *URLDB) createURLtable() error {
 		return fmt.Errorf("Index creation error: %w", err)
 	}
 
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+	`)
+	if err != nil {
+		return fmt.Errorf("URL table migration error: %w", err)
+	}
+
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner_id INT REFERENCES users(id) ON DELETE SET NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("URL table migration error: %w", err)
+	}
+
+	// Counter behind the "sequence" code strategy, shared by every replica.
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE SEQUENCE IF NOT EXISTS short_code_seq MINVALUE 0 START 0;
+	`)
+	if err != nil {
+		return fmt.Errorf("Sequence creation error: %w", err)
+	}
+
+	return nil
+}
+
+// NextCodeSequence returns the next value of the short code counter. Values
+// are never handed out twice, even across replicas and restarts.
+func (URLDB *URLDB) NextCodeSequence() (uint64, error) {
+	var n int64
+	err := URLDB.DB.QueryRow(URLDB.Ctx, "SELECT nextval('short_code_seq')").Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting next code sequence: %w", err)
+	}
+	return uint64(n), nil
+}
+
+func (URLDB *URLDB) createClicktable() error {
+	_, err := URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE TABLE IF NOT EXISTS url_clicks (
+			url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+			day DATE NOT NULL,
+			count BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (url_id, day)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Click table creation error: %w", err)
+	}
+
 	return nil
 }
 
@@ -131,12 +348,42 @@ func (URLDB *URLDB) createUsertable() error {
 }
 
 func (URLDB *URLDB) CreateTables() error {
-	err := URLDB.createURLtable()
+	err := URLDB.createUsertable()
 	if err != nil {
 		return err
 	}
 
-	err = URLDB.createUsertable()
+	err = URLDB.createURLtable()
+	if err != nil {
+		return err
+	}
+
+	err = URLDB.createClicktable()
+	if err != nil {
+		return err
+	}
+
+	err = URLDB.createTagtables()
+	if err != nil {
+		return err
+	}
+
+	err = URLDB.createFoldertables()
+	if err != nil {
+		return err
+	}
+
+	err = URLDB.createAPIKeytable()
+	if err != nil {
+		return err
+	}
+
+	err = URLDB.createJobtable()
+	if err != nil {
+		return err
+	}
+
+	err = URLDB.createQuotatables()
 	if err != nil {
 		return err
 	}
@@ -145,123 +392,281 @@ func (URLDB *URLDB) CreateTables() error {
 }
 
 func (db *URLDB) startInsertWorkers(n int) {
+	db.workerCtx, db.cancelWorkers = context.WithCancel(db.Ctx)
 	for i := range n {
 		go func(workerID int) {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("Worker %d recovered from panic: %v", workerID, r)
-				}
-			}()
-
 			for pair := range db.insertQueue {
-				ctx, cancel := context.WithTimeout(db.Ctx, 5*time.Second)
-				defer cancel()
-
-				redisShort := fmt.Sprintf("URL:%s", pair.short)
-				err := db.Redis.Set(ctx, redisShort, pair.long, 24*time.Hour).Err()
-				if err != nil {
-					log.Printf("Worker %d: Redis insert error for %s: %v", workerID, pair.short, err)
-					db.Wg.Done()
-					continue
+				if db.abandon.Load() {
+					db.insertStats.dropped.Add(1)
+				} else if db.insertOne(workerID, pair) == nil {
+					db.insertStats.flushed.Add(1)
+				} else {
+					db.insertStats.failed.Add(1)
 				}
-
-				maxRetries := 3
-				for attempt := 1; attempt <= maxRetries; attempt++ {
-					_, err = db.DB.Exec(ctx, `
-                        INSERT INTO urls (short, long) 
-                        VALUES ($1, $2) 
-                        ON CONFLICT (short) DO NOTHING`,
-						pair.short, pair.long)
-
-					if err == nil {
-						break // Success
-					}
-
-					if attempt < maxRetries {
-						log.Printf("Worker %d: Insert attempt %d failed for %s: %v",
-							workerID, attempt, pair.short, err)
-						time.Sleep(time.Duration(attempt) * time.Second) // Exponential backoff
-					} else {
-						log.Printf("Worker %d: Final insert failed for %s: %v",
-							workerID, pair.short, err)
-					}
-				}
-
 				db.Wg.Done()
 			}
 		}(i)
 	}
 }
 
-func (URLDB *URLDB) SaveURL(short, long string) error {
-	URLDB.Cache.Set(short, long, 5*time.Minute)
+// insertOne writes pair to Redis and Postgres. A code that turns out to be
+// taken is not retried: the caches are cleared so the existing link is served
+// again, and ErrCodeTaken is returned.
+func (db *URLDB) insertOne(workerID int, pair NewURL) (err error) {
+	ctx, cancel := context.WithTimeout(utils.WithLogFields(db.workerCtx, pair.logFields...), 5*time.Second)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "insert worker recovered from panic", "worker", workerID, "panic", r)
+			err = fmt.Errorf("insert worker panic: %v", r)
+		}
+	}()
+
+	err = db.Redis.Set(ctx, redisKey(pair.Short), pair.Long, capTTL(db.cacheTTLs().Redis, pair.ExpiresAt)).Err()
+	if err != nil {
+		slog.ErrorContext(ctx, "insert worker: Redis write failed", "worker", workerID, "short_code", pair.Short, "err", err)
+		return err
+	}
+	db.announceCreated(ctx, pair.Short)
+
+	maxRetries := 3
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		var id int64
+		err = db.DB.QueryRow(ctx, insertURLSQL,
+			pair.Short, pair.Long, pair.ExpiresAt, pair.Tags, pair.OwnerID).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			slog.ErrorContext(ctx, "insert worker: short code already taken, link not stored",
+				"worker", workerID, "short_code", pair.Short)
+			db.Redis.Del(ctx, redisKey(pair.Short))
+			db.Invalidate(ctx, pair.Short)
+			return ErrCodeTaken
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt < maxRetries {
+			db.insertStats.retries.Add(1)
+			slog.WarnContext(ctx, "insert worker: insert attempt failed",
+				"worker", workerID, "attempt", attempt, "short_code", pair.Short, "err", err)
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second): // Exponential backoff
+			case <-ctx.Done():
+			}
+		} else {
+			slog.ErrorContext(ctx, "insert worker: insert failed, giving up",
+				"worker", workerID, "short_code", pair.Short, "err", err)
+		}
+	}
+	return err
+}
+
+// SaveURL caches the link and queues it for the insert workers. The workers
+// log with ctx's log fields.
+func (URLDB *URLDB) SaveURL(ctx context.Context, url NewURL) error {
+	URLDB.queueMu.RLock()
+	defer URLDB.queueMu.RUnlock()
+	if URLDB.closing {
+		return ErrShuttingDown
+	}
+
+	url.logFields = utils.LogFields(ctx)
+	URLDB.Cache.Set(url.Short, url.Long, capTTL(URLDB.localTTL(), url.ExpiresAt))
+	URLDB.Wg.Add(1)
 	select {
-	case URLDB.insertQueue <- urlpair{short: short, long: long}:
-		URLDB.Wg.Add(1)
+	case URLDB.insertQueue <- url:
+		URLDB.noteCreated(url.Short)
 		return nil
 	default:
-		return fmt.Errorf("insert queue is full")
+		URLDB.Wg.Done()
+		return ErrQueueFull
 	}
 }
 
-func (URLDB *URLDB) GetURL(short string) (string, error) {
-	longcache, exists := URLDB.Cache.Get(short)
+// ErrNotFound is returned for codes that don't exist, or that belong to
+// another owner when an owner is given; ErrExpired by GetURL for ones whose
+// expiry has passed.
+var (
+	ErrNotFound = customerrors.NotFound("short url not found")
+	ErrExpired  = customerrors.Gone("short url has expired")
+)
+
+// lookupTimeout bounds a shared fetch, which runs detached from the request
+// that started it so that one caller going away doesn't fail the others.
+const lookupTimeout = 2 * time.Second
+
+// GetURL returns the destination of short, ErrNotFound or ErrExpired. It
+// tries each tier once: the local cache, the negative cache and code filter,
+// Redis, then Postgres, back-filling the faster tiers from the slower ones.
+// Concurrent misses for the same code share a single fetch.
+func (URLDB *URLDB) GetURL(ctx context.Context, short string) (string, error) {
+	ctx, span := tracer.Start(ctx, "Storage.GetURL", trace.WithAttributes(attribute.String("short_code", short)))
+	defer span.End()
+
+	_, local := tracer.Start(ctx, "cache.local")
+	longcache, expiry, exists := URLDB.Cache.GetWithExpiry(short)
+	local.SetAttributes(attribute.Bool("cache.hit", exists))
+	local.End()
 	if exists {
+		if URLDB.shouldRefreshEarly(expiry) {
+			URLDB.refreshAsync(short)
+		}
 		return longcache, nil
 	}
-	redisShort := fmt.Sprintf("URL:%s", short)
-	if val, err := URLDB.Redis.Get(URLDB.Ctx, redisShort).Result(); err != redis.Nil {
+	_, negative := tracer.Start(ctx, "cache.negative")
+	missing := URLDB.knownMissing(short)
+	negative.SetAttributes(attribute.Bool("cache.hit", missing != nil))
+	negative.End()
+	if missing != nil {
+		return "", missing
+	}
+
+	// Shared is also set for the caller whose fetch the others joined.
+	leader := false
+	ch := URLDB.lookups.DoChan(short, func() (any, error) {
+		leader = true
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lookupTimeout)
+		defer cancel()
+		return URLDB.fetchURL(ctx, short)
+	})
+	select {
+	case res := <-ch:
+		// A shared fetch's spans belong to the request that started it.
+		span.SetAttributes(attribute.Bool("lookup.coalesced", res.Shared && !leader))
+		if res.Shared && !leader {
+			URLDB.lookupStats.coalesced.Add(1)
+		}
+		if res.Err != nil {
+			if !errors.Is(res.Err, ErrNotFound) && !errors.Is(res.Err, ErrExpired) {
+				tracing.RecordError(span, res.Err)
+			}
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		tracing.RecordError(span, ctx.Err())
+		return "", ctx.Err()
+	}
+}
+
+// fetchURL reads short from Redis, falling back to Postgres, and fills the
+// caches on the way out. A Redis error is logged and treated as a miss.
+func (URLDB *URLDB) fetchURL(ctx context.Context, short string) (string, error) {
+	redisCtx, redisSpan := tracer.Start(ctx, "cache.redis")
+	// Redis keys never outlive their link's expiry, so the key's remaining
+	// TTL caps how long the local copy may live.
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	// Errors come back on the commands themselves.
+	URLDB.Redis.Pipelined(redisCtx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(redisCtx, redisKey(short))
+		pttl = pipe.PTTL(redisCtx, redisKey(short))
+		return nil
+	})
+	val, err := get.Result()
+	redisSpan.SetAttributes(attribute.Bool("cache.hit", err == nil))
+	if err != nil && err != redis.Nil {
+		tracing.RecordError(redisSpan, err)
+	}
+	redisSpan.End()
+	if err == nil {
+		URLDB.lookupStats.redisHits.Add(1)
+		ttl := URLDB.localTTL()
+		if remaining := pttl.Val(); remaining > 0 {
+			ttl = min(ttl, remaining)
+		}
+		URLDB.Cache.Set(short, val, ttl)
 		return val, nil
 	}
+	if err == redis.Nil {
+		URLDB.lookupStats.redisMisses.Add(1)
+	} else {
+		URLDB.lookupStats.redisErrors.Add(1)
+		slog.WarnContext(ctx, "redis get failed", "short_code", short, "err", err)
+	}
 
-	var long string
-	err := URLDB.DB.QueryRow(URLDB.Ctx, "SELECT long FROM urls WHERE short = $1 LIMIT 1", short).Scan(&long)
+	pgCtx, pgSpan := tracer.Start(ctx, "store.postgres")
+	defer pgSpan.End()
+	long, expiresAt, err := URLDB.source.Link(pgCtx, short)
+	pgSpan.SetAttributes(attribute.Bool("db.found", err == nil))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("not found")
+		if errors.Is(err, ErrNotFound) {
+			URLDB.rememberMissing(short)
+			return "", ErrNotFound
 		}
-		return "", fmt.Errorf("sqlite fetch error: %w", err)
+		tracing.RecordError(pgSpan, err)
+		return "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		URLDB.rememberExpired(short)
+		return "", ErrExpired
 	}
 
+	URLDB.Cache.Set(short, long, capTTL(URLDB.localTTL(), expiresAt))
 	go func() {
-		URLDB.Cache.Set(short, long, 5*time.Minute)
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 100*time.Millisecond)
 		defer cancel()
-		err = URLDB.Redis.Set(ctx, short, long, time.Hour*24).Err()
+		err := URLDB.Redis.Set(ctx, redisKey(short), long, capTTL(URLDB.cacheTTLs().Redis, expiresAt)).Err()
 		if err != nil {
-			fmt.Printf("redis set error: %v\n", err)
+			slog.WarnContext(ctx, "redis set failed", "short_code", short, "err", err)
 		}
 	}()
 	return long, nil
 }
 
+// DeleteURL removes the link everywhere. Other replicas are told to drop it
+// only after Redis is updated, so they can't re-read the old value.
 func (URLDB *URLDB) DeleteURL(short string) error {
 	URLDB.Cache.Delete(short)
-	redisShort := fmt.Sprintf("URL:%s", short)
+	redisShort := redisKey(short)
 
 	_, err := URLDB.DB.Exec(URLDB.Ctx, "DELETE FROM urls WHERE short = $1", short)
 	if err != nil {
 		return fmt.Errorf("Errors Deleting URL: %w", err)
 	}
 
-	return URLDB.Redis.Del(URLDB.Ctx, redisShort).Err()
+	err = URLDB.Redis.Del(URLDB.Ctx, redisShort).Err()
+	URLDB.Invalidate(URLDB.Ctx, short)
+	URLDB.rememberMissing(short)
+	return err
 }
 
+// EditURL changes short's destination. The caches only take the new value
+// until the link expires; an expired link is dropped from them instead.
 func (URLDB *URLDB) EditURL(short string, newlong string) error {
 	URLDB.Cache.Delete(short)
-	URLDB.Cache.Set(short, newlong, 5*time.Minute)
-	redisShort := fmt.Sprintf("URL:%s", short)
+	redisShort := redisKey(short)
 
-	_, err := URLDB.DB.Exec(URLDB.Ctx, "UPDATE urls SET long = $1 WHERE short = $2", newlong, short)
+	var expiresAt *time.Time
+	err := URLDB.DB.QueryRow(URLDB.Ctx, "UPDATE urls SET long = $1 WHERE short = $2 RETURNING expires_at", newlong, short).Scan(&expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("Error updating urls: %w", err)
 	}
 
-	return URLDB.Redis.Set(URLDB.Ctx, redisShort, newlong, time.Hour*24).Err()
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		err = URLDB.Redis.Del(URLDB.Ctx, redisShort).Err()
+		URLDB.Invalidate(URLDB.Ctx, short)
+		return err
+	}
+	err = URLDB.Redis.Set(URLDB.Ctx, redisShort, newlong, capTTL(URLDB.cacheTTLs().Redis, expiresAt)).Err()
+	URLDB.Invalidate(URLDB.Ctx, short)
+	if err != nil {
+		return err
+	}
+	URLDB.Cache.Set(short, newlong, capTTL(URLDB.localTTL(), expiresAt))
+	return nil
 }
 
 func (URLDB *URLDB) CheckShortURLExists(short string) (bool, error) {
-	redisShort := fmt.Sprintf("URL:%s", short)
+	// Expired codes are still taken.
+	if errors.Is(URLDB.knownMissing(short), ErrNotFound) {
+		return false, nil
+	}
+	redisShort := redisKey(short)
 
 	Exists, err := URLDB.Redis.Exists(URLDB.Ctx, redisShort).Result()
 	if err != nil {
@@ -276,6 +681,9 @@ func (URLDB *URLDB) CheckShortURLExists(short string) (bool, error) {
 	if err != nil {
 		return true, fmt.Errorf("Error checking if short url exists: %w", err)
 	}
+	if !exists {
+		URLDB.rememberMissing(short)
+	}
 
 	return exists, nil
 }
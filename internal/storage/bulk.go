@@ -0,0 +1,91 @@
+package Storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewURL is a link to be inserted by SaveURL or SaveURLsBatch.
+type NewURL struct {
+	Short     string
+	Long      string
+	ExpiresAt *time.Time
+	Tags      []string
+	OwnerID   *int64
+	// logFields are the queuing request's, so the insert worker's logs can
+	// be matched to it.
+	logFields []slog.Attr
+}
+
+// SaveURLsBatch inserts links in a single round trip and reports, per item,
+// whether it was inserted (false means the short code was already taken).
+// Unlike SaveURL it writes to Postgres before returning, so callers know which
+// codes are really theirs. The whole batch runs in one implicit transaction:
+// an error means nothing was inserted.
+func (URLDB *URLDB) SaveURLsBatch(ctx context.Context, items []NewURL) ([]bool, error) {
+	batch := &pgx.Batch{}
+	for _, item := range items {
+		batch.Queue(insertURLSQL,
+			item.Short, item.Long, item.ExpiresAt, item.Tags, item.OwnerID)
+	}
+
+	results := URLDB.DB.SendBatch(ctx, batch)
+	inserted := make([]bool, len(items))
+	for i, item := range items {
+		var id int64
+		err := results.QueryRow().Scan(&id)
+		switch {
+		case err == nil:
+			inserted[i] = true
+		case errors.Is(err, pgx.ErrNoRows):
+		default:
+			results.Close()
+			return nil, fmt.Errorf("batch insert error for %s: %w", item.Short, err)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, fmt.Errorf("batch insert error: %w", err)
+	}
+
+	pipe := URLDB.Redis.Pipeline()
+	created := make([]string, 0, len(items))
+	for i, item := range items {
+		if !inserted[i] {
+			continue
+		}
+		ttl := capTTL(URLDB.cacheTTLs().Redis, item.ExpiresAt)
+		pipe.Set(ctx, redisKey(item.Short), item.Long, ttl)
+		created = append(created, item.Short)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		// Postgres is the source of truth; a cold Redis only costs a lookup.
+		slog.WarnContext(ctx, "redis batch set failed", "err", err)
+	}
+	URLDB.noteCreated(created...)
+	URLDB.announceCreated(ctx, created...)
+
+	return inserted, nil
+}
+
+// redisKey is the Redis key holding the destination of short.
+func redisKey(short string) string {
+	return "URL:" + short
+}
+
+// capTTL shortens ttl so a cached entry never outlives the link's expiry.
+func capTTL(ttl time.Duration, expiresAt *time.Time) time.Duration {
+	if expiresAt == nil {
+		return ttl
+	}
+	remaining := time.Until(*expiresAt)
+	if remaining <= 0 {
+		return time.Millisecond
+	}
+	return min(ttl, remaining)
+}
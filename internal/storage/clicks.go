@@ -0,0 +1,96 @@
+package Storage
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// clickCounter buffers redirect counts in memory and adds them to the daily
+// url_clicks rows on every tick, so a hot link costs one UPDATE per interval
+// instead of one per redirect.
+type clickCounter struct {
+	db      *URLDB
+	mu      sync.Mutex
+	pending map[string]int64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newClickCounter(db *URLDB, interval time.Duration) *clickCounter {
+	c := &clickCounter{
+		db:      db,
+		pending: make(map[string]int64),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.run(interval)
+	return c
+}
+
+func (c *clickCounter) Add(short string) {
+	c.mu.Lock()
+	c.pending[short]++
+	c.mu.Unlock()
+}
+
+// Stop flushes whatever is still buffered.
+func (c *clickCounter) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *clickCounter) run(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.stop:
+			c.flush()
+			return
+		}
+	}
+}
+
+func (c *clickCounter) flush() {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	counts := c.pending
+	c.pending = make(map[string]int64, len(counts))
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	batch := &pgx.Batch{}
+	for short, n := range counts {
+		batch.Queue(`
+			INSERT INTO url_clicks (url_id, day, count)
+			SELECT id, $2, $3 FROM urls WHERE short = $1
+			ON CONFLICT (url_id, day) DO UPDATE SET count = url_clicks.count + EXCLUDED.count`,
+			short, day, n)
+	}
+	if err := c.db.DB.SendBatch(ctx, batch).Close(); err != nil {
+		slog.Error("click flush failed, counts lost", "links", len(counts), "err", err)
+	}
+	if err := c.db.trackHotKeys(ctx, counts); err != nil {
+		slog.Error("hot key tracking failed", "err", err)
+	}
+}
+
+// RecordClick counts a redirect for short. Counts reach Postgres
+// asynchronously.
+func (URLDB *URLDB) RecordClick(short string) {
+	URLDB.clicks.Add(short)
+}
@@ -0,0 +1,86 @@
+package Storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const exportFetchSize = 1000
+
+// ExportRow is one link as it leaves the system for the warehouse.
+type ExportRow struct {
+	Code        string     `json:"code" parquet:"code"`
+	Destination string     `json:"destination" parquet:"destination"`
+	OwnerID     *int64     `json:"owner_id" parquet:"owner_id,optional"`
+	CreatedAt   time.Time  `json:"created_at" parquet:"created_at,timestamp(millisecond)"`
+	ExpiresAt   *time.Time `json:"expires_at" parquet:"expires_at,optional"`
+	Expired     bool       `json:"expired" parquet:"expired"`
+	Clicks      *int64     `json:"clicks,omitempty" parquet:"clicks,optional"`
+}
+
+type ExportOptions struct {
+	// WithClicks adds the total click count of every link.
+	WithClicks bool
+}
+
+// ExportLinks calls fn for every link in id order. Rows are read through a
+// server-side cursor in chunks of exportFetchSize, so memory stays flat
+// however large the table is. Returning an error from fn stops the export.
+func (URLDB *URLDB) ExportLinks(ctx context.Context, opts ExportOptions, fn func(ExportRow) error) error {
+	tx, err := URLDB.DB.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly, IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		return fmt.Errorf("Error starting export: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	clicks := "NULL::BIGINT"
+	if opts.WithClicks {
+		clicks = "(SELECT COALESCE(SUM(c.count), 0) FROM url_clicks c WHERE c.url_id = u.id)"
+	}
+	_, err = tx.Exec(ctx, `
+		DECLARE export_cursor NO SCROLL CURSOR FOR
+		SELECT u.short, u.long, u.owner_id, u.created_at, u.expires_at,
+			COALESCE(u.expires_at <= NOW(), FALSE), `+clicks+`
+		FROM urls u
+		ORDER BY u.id`)
+	if err != nil {
+		return fmt.Errorf("Error declaring export cursor: %w", err)
+	}
+
+	for {
+		rows, err := tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM export_cursor", exportFetchSize))
+		if err != nil {
+			return fmt.Errorf("Error fetching export rows: %w", err)
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			var row ExportRow
+			var owner *int32
+			err := rows.Scan(&row.Code, &row.Destination, &owner, &row.CreatedAt,
+				&row.ExpiresAt, &row.Expired, &row.Clicks)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("Error scanning export row: %w", err)
+			}
+			if owner != nil {
+				id := int64(*owner)
+				row.OwnerID = &id
+			}
+			if err := fn(row); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("Error fetching export rows: %w", err)
+		}
+		if n < exportFetchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package Storage
+
+import (
+	"context"
+	"hash/maphash"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	filterFalsePositiveRate = 0.01
+	// filterMinCapacity keeps a young database from getting a filter that
+	// saturates after the first few thousand creates.
+	filterMinCapacity     = 1 << 20
+	filterRebuildInterval = time.Hour
+)
+
+// BloomFilter is a fixed-size set that can answer "definitely not present"
+// or "maybe present". It is not safe for concurrent use.
+type BloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+	seed maphash.Seed
+}
+
+// NewBloomFilter sizes a filter for n keys at the given false positive rate.
+func NewBloomFilter(n int, fpRate float64) *BloomFilter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &BloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    max(k, 1),
+		seed: maphash.MakeSeed(),
+	}
+}
+
+func (f *BloomFilter) Add(key string) {
+	h1, h2 := f.hashes(key)
+	for i := range f.k {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *BloomFilter) MayContain(key string) bool {
+	h1, h2 := f.hashes(key)
+	for i := range f.k {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes splits one 64-bit hash into the two used for double hashing.
+func (f *BloomFilter) hashes(key string) (uint64, uint64) {
+	h := maphash.String(f.seed, key)
+	return h & math.MaxUint32, h>>32 | 1
+}
+
+// codeFilter is the Bloom filter of every live short code. A Bloom filter
+// can't forget, so deleted codes stay "maybe present" until the next
+// periodic rebuild; the negative cache answers for them in the meantime.
+type codeFilter struct {
+	mu     sync.RWMutex
+	bloom  *BloomFilter
+	ready  atomic.Bool
+	gen    atomic.Uint64
+	count  func(ctx context.Context) (int, error)
+	scan   func(ctx context.Context, add func(string)) error
+	stop   chan struct{}
+	stopMu sync.Once
+
+	// Codes added while a rebuild is streaming are replayed onto the new
+	// filter, so a create racing the rebuild isn't lost.
+	rebuildMu sync.Mutex
+	building  bool
+	pending   []string
+}
+
+func newCodeFilter(count func(ctx context.Context) (int, error), scan func(ctx context.Context, add func(string)) error) *codeFilter {
+	f := &codeFilter{count: count, scan: scan, stop: make(chan struct{})}
+	go f.run()
+	return f
+}
+
+// Ready reports whether the filter holds every code; until then callers must
+// fall through to Redis and Postgres.
+func (f *codeFilter) Ready() bool {
+	return f.ready.Load()
+}
+
+func (f *codeFilter) Add(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bloom != nil {
+		f.bloom.Add(key)
+	}
+	if f.building {
+		f.pending = append(f.pending, key)
+	}
+}
+
+func (f *codeFilter) MayContain(key string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.bloom == nil || f.bloom.MayContain(key)
+}
+
+// Invalidate stops the filter from being trusted until a rebuild started
+// after this call completes.
+func (f *codeFilter) Invalidate() {
+	f.gen.Add(1)
+	f.ready.Store(false)
+}
+
+func (f *codeFilter) Rebuild(ctx context.Context) error {
+	f.rebuildMu.Lock()
+	defer f.rebuildMu.Unlock()
+
+	gen := f.gen.Load()
+	f.mu.Lock()
+	f.building = true
+	f.pending = nil
+	f.mu.Unlock()
+
+	var bloom *BloomFilter
+	n, err := f.count(ctx)
+	if err == nil {
+		bloom = NewBloomFilter(max(2*n, filterMinCapacity), filterFalsePositiveRate)
+		err = f.scan(ctx, bloom.Add)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.building = false
+	if err != nil {
+		f.pending = nil
+		return err
+	}
+	for _, code := range f.pending {
+		bloom.Add(code)
+	}
+	f.pending = nil
+	f.bloom = bloom
+	f.ready.Store(f.gen.Load() == gen)
+	return nil
+}
+
+func (f *codeFilter) run() {
+	ticker := time.NewTicker(filterRebuildInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Rebuild(context.Background()); err != nil {
+				slog.Error("code filter rebuild failed", "err", err)
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *codeFilter) Stop() {
+	f.stopMu.Do(func() { close(f.stop) })
+}
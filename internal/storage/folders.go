@@ -0,0 +1,152 @@
+package Storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrFolderNotFound = customerrors.NotFound("folder not found")
+	ErrFolderExists   = customerrors.Conflict("a folder with that name already exists")
+)
+
+// Folder groups an owner's links. Unlike tags, a link is in at most one
+// folder, and folders exist until they are deleted even when empty.
+type Folder struct {
+	Name      string    `json:"name"`
+	Links     int64     `json:"links"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (URLDB *URLDB) createFoldertables() error {
+	_, err := URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE TABLE IF NOT EXISTS folders (
+			id BIGSERIAL PRIMARY KEY,
+			owner_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (owner_id, name)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Folder table creation error: %w", err)
+	}
+
+	// Deleting a folder leaves its links unfiled.
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS folder_id BIGINT REFERENCES folders(id) ON DELETE SET NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("URL table migration error: %w", err)
+	}
+
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_urls_folder ON urls(folder_id, id DESC) WHERE folder_id IS NOT NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("Index creation error: %w", err)
+	}
+
+	return nil
+}
+
+func (URLDB *URLDB) CreateFolder(ctx context.Context, owner int64, name string) error {
+	_, err := URLDB.DB.Exec(ctx, `
+		INSERT INTO folders (owner_id, name) VALUES ($1, $2)`, owner, name)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrFolderExists
+	}
+	if err != nil {
+		return fmt.Errorf("Error creating folder: %w", err)
+	}
+	return nil
+}
+
+func (URLDB *URLDB) RenameFolder(ctx context.Context, owner int64, from, to string) error {
+	tag, err := URLDB.DB.Exec(ctx, `
+		UPDATE folders SET name = $3 WHERE owner_id = $1 AND name = $2`,
+		owner, from, to)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrFolderExists
+	}
+	if err != nil {
+		return fmt.Errorf("Error renaming folder: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+// DeleteFolder removes one of owner's folders; its links are kept, unfiled.
+func (URLDB *URLDB) DeleteFolder(ctx context.Context, owner int64, name string) error {
+	tag, err := URLDB.DB.Exec(ctx, `
+		DELETE FROM folders WHERE owner_id = $1 AND name = $2`, owner, name)
+	if err != nil {
+		return fmt.Errorf("Error deleting folder: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+// Folders returns every folder of owner with its link count, by name.
+func (URLDB *URLDB) Folders(ctx context.Context, owner int64) ([]Folder, error) {
+	rows, err := URLDB.DB.Query(ctx, `
+		SELECT f.name, COUNT(u.id), f.created_at
+		FROM folders f
+		LEFT JOIN urls u ON u.folder_id = f.id
+		WHERE f.owner_id = $1
+		GROUP BY f.id, f.name, f.created_at
+		ORDER BY f.name`, owner)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing folders: %w", err)
+	}
+	defer rows.Close()
+
+	folders := []Folder{}
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(&folder.Name, &folder.Links, &folder.CreatedAt); err != nil {
+			return nil, fmt.Errorf("Error listing folders: %w", err)
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// SetLinkFolder files one of owner's links in the named folder, or takes it
+// out of its folder when folder is "".
+func (URLDB *URLDB) SetLinkFolder(ctx context.Context, owner int64, short, folder string) error {
+	var folderID *int64
+	if folder != "" {
+		err := URLDB.DB.QueryRow(ctx, `
+			SELECT id FROM folders WHERE owner_id = $1 AND name = $2`,
+			owner, folder).Scan(&folderID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrFolderNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("Error moving link: %w", err)
+		}
+	}
+	tag, err := URLDB.DB.Exec(ctx, `
+		UPDATE urls SET folder_id = $3 WHERE short = $1 AND owner_id = $2`,
+		short, owner, folderID)
+	if err != nil {
+		return fmt.Errorf("Error moving link: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
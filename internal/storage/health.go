@@ -0,0 +1,82 @@
+package Storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// insertQueueSaturation is how full the insert queue may get before the
+// replica reports itself not ready, leaving headroom for requests in flight.
+const insertQueueSaturation = 0.9
+
+// DependencyStatus is the outcome of pinging one backing service.
+type DependencyStatus struct {
+	Healthy   bool    `json:"healthy"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Dependencies reports on each backing service, by name.
+type Dependencies map[string]DependencyStatus
+
+// Healthy reports whether every dependency answered.
+func (d Dependencies) Healthy() bool {
+	for _, status := range d {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// PingDependencies pings Postgres and Redis at the same time, so a slow one
+// doesn't use up the other's share of ctx.
+func (URLDB *URLDB) PingDependencies(ctx context.Context) Dependencies {
+	pings := map[string]func(context.Context) error{
+		"postgres": URLDB.DB.Ping,
+		"redis":    func(ctx context.Context) error { return URLDB.Redis.Ping(ctx).Err() },
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	deps := make(Dependencies, len(pings))
+	for name, ping := range pings {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := ping(ctx)
+			status := DependencyStatus{
+				Healthy:   err == nil,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				status.Error = err.Error()
+			}
+			mu.Lock()
+			deps[name] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return deps
+}
+
+// InsertQueueSaturated reports whether new links are close to being turned
+// away because the insert workers are behind.
+func (URLDB *URLDB) InsertQueueSaturated() bool {
+	stats := URLDB.InsertStats()
+	return float64(stats.Depth) >= insertQueueSaturation*float64(stats.Capacity)
+}
+
+// InvalidationConnected reports whether this replica is hearing about
+// changes made on the others.
+func (URLDB *URLDB) InvalidationConnected() bool {
+	return URLDB.bus != nil && URLDB.bus.Healthy()
+}
+
+// WaitInvalidationConnected blocks until the invalidation bus has connected
+// once, or ctx is done.
+func (URLDB *URLDB) WaitInvalidationConnected(ctx context.Context) error {
+	return URLDB.bus.WaitHealthy(ctx)
+}
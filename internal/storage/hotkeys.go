@@ -0,0 +1,174 @@
+package Storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Hot keys are tracked per hour in Redis, shared by all replicas: a
+// count-min sketch (one hash, "row:column" fields) estimates each code's
+// redirects and a sorted set keeps the top hotKeyTopN by that estimate.
+// Reads merge the current and previous hour so a new window isn't empty.
+const (
+	hotKeyWindow = time.Hour
+	hotKeyTopN   = 1000
+	sketchWidth  = 2048
+	sketchDepth  = 4
+
+	// WarmCacheSize is how many hot codes a replica preloads on startup.
+	WarmCacheSize = 500
+)
+
+type HotKey struct {
+	Code  string `json:"code"`
+	Count int64  `json:"count"`
+}
+
+func hotKeyKeys(window int64) (sketch, top string) {
+	return fmt.Sprintf("hot:cms:%d", window), fmt.Sprintf("hot:top:%d", window)
+}
+
+// sketchCells returns the field of short in each row of the sketch. The hash
+// is FNV-1a seeded with the row number, so every replica agrees on it.
+func sketchCells(short string) [sketchDepth]string {
+	var cells [sketchDepth]string
+	for row := range sketchDepth {
+		h := uint64(14695981039346656037)
+		h ^= uint64(row)
+		h *= 1099511628211
+		for i := 0; i < len(short); i++ {
+			h ^= uint64(short[i])
+			h *= 1099511628211
+		}
+		cells[row] = strconv.Itoa(row) + ":" + strconv.FormatUint(h%sketchWidth, 10)
+	}
+	return cells
+}
+
+// trackHotKeys adds a batch of redirect counts to the current window.
+func (URLDB *URLDB) trackHotKeys(ctx context.Context, counts map[string]int64) error {
+	sketch, top := hotKeyKeys(time.Now().Unix() / int64(hotKeyWindow.Seconds()))
+
+	pipe := URLDB.Redis.Pipeline()
+	incrs := make(map[string][sketchDepth]*redis.IntCmd, len(counts))
+	for short, n := range counts {
+		var cmds [sketchDepth]*redis.IntCmd
+		for row, cell := range sketchCells(short) {
+			cmds[row] = pipe.HIncrBy(ctx, sketch, cell, n)
+		}
+		incrs[short] = cmds
+	}
+	pipe.Expire(ctx, sketch, 2*hotKeyWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("Error updating hot key sketch: %w", err)
+	}
+
+	members := make([]redis.Z, 0, len(counts))
+	for short, cmds := range incrs {
+		estimate := cmds[0].Val()
+		for _, cmd := range cmds[1:] {
+			estimate = min(estimate, cmd.Val())
+		}
+		members = append(members, redis.Z{Score: float64(estimate), Member: short})
+	}
+	pipe = URLDB.Redis.Pipeline()
+	pipe.ZAddGT(ctx, top, members...)
+	pipe.ZRemRangeByRank(ctx, top, 0, -hotKeyTopN-1)
+	pipe.Expire(ctx, top, 2*hotKeyWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("Error updating hot keys: %w", err)
+	}
+	return nil
+}
+
+// HotKeys returns up to limit of the most requested codes over roughly the
+// last one to two hours, most requested first. Counts are estimates and may
+// overcount, never undercount.
+func (URLDB *URLDB) HotKeys(ctx context.Context, limit int) ([]HotKey, error) {
+	window := time.Now().Unix() / int64(hotKeyWindow.Seconds())
+	counts := make(map[string]int64)
+	for _, w := range []int64{window, window - 1} {
+		_, top := hotKeyKeys(w)
+		entries, err := URLDB.Redis.ZRevRangeWithScores(ctx, top, 0, int64(limit)-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading hot keys: %w", err)
+		}
+		for _, entry := range entries {
+			counts[entry.Member.(string)] += int64(entry.Score)
+		}
+	}
+
+	keys := make([]HotKey, 0, len(counts))
+	for code, n := range counts {
+		keys = append(keys, HotKey{Code: code, Count: n})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Code < keys[j].Code
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// WarmCache preloads the hottest limit codes into the local cache and Redis
+// from Postgres, and returns how many it loaded. It first gives the
+// invalidation bus a moment to connect, since connecting purges the local
+// cache and entries cached before then get the short fallback TTL.
+func (URLDB *URLDB) WarmCache(ctx context.Context, limit int) (int, error) {
+	if URLDB.bus != nil {
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		URLDB.bus.WaitHealthy(waitCtx)
+		cancel()
+	}
+
+	hot, err := URLDB.HotKeys(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+	if len(hot) == 0 {
+		return 0, nil
+	}
+	codes := make([]string, len(hot))
+	for i, key := range hot {
+		codes[i] = key.Code
+	}
+
+	rows, err := URLDB.DB.Query(ctx, `
+		SELECT short, long, expires_at FROM urls
+		WHERE short = ANY($1) AND (expires_at IS NULL OR expires_at > NOW())`, codes)
+	if err != nil {
+		return 0, fmt.Errorf("Error loading hot keys: %w", err)
+	}
+	defer rows.Close()
+
+	pipe := URLDB.Redis.Pipeline()
+	loaded := 0
+	for rows.Next() {
+		var short, long string
+		var expiresAt *time.Time
+		if err := rows.Scan(&short, &long, &expiresAt); err != nil {
+			return loaded, fmt.Errorf("Error loading hot keys: %w", err)
+		}
+		URLDB.Cache.Set(short, long, capTTL(URLDB.localTTL(), expiresAt))
+		pipe.Set(ctx, redisKey(short), long, capTTL(URLDB.cacheTTLs().Redis, expiresAt))
+		loaded++
+	}
+	if err := rows.Err(); err != nil {
+		return loaded, fmt.Errorf("Error loading hot keys: %w", err)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		// The local cache is warm either way.
+		slog.WarnContext(ctx, "redis cache warm failed", "err", err)
+	}
+	return loaded, nil
+}
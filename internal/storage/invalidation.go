@@ -0,0 +1,262 @@
+package Storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	invalidationChannel = "cache:invalidate"
+
+	busPingInterval = 10 * time.Second
+	busMaxBackoff   = 30 * time.Second
+)
+
+// invalidationBus tells the other replicas to drop keys from their local
+// cache after a write ("del") or that a code now exists ("add"). Messages are
+// "<sender>|<op>|<key>"; a replica ignores its own.
+type invalidationBus struct {
+	redis      *redis.Client
+	cache      *Cache
+	negative   *Cache
+	filter     *codeFilter
+	instanceID string
+	healthy    atomic.Bool
+	connected  chan struct{}
+	firstOnce  sync.Once
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func newInvalidationBus(rdb *redis.Client, cache, negative *Cache, filter *codeFilter) *invalidationBus {
+	id := make([]byte, 8)
+	rand.Read(id)
+	bus := &invalidationBus{
+		redis:      rdb,
+		cache:      cache,
+		negative:   negative,
+		filter:     filter,
+		instanceID: hex.EncodeToString(id),
+		connected:  make(chan struct{}),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go bus.run()
+	return bus
+}
+
+// WaitHealthy blocks until the bus has connected once, or ctx is done.
+func (bus *invalidationBus) WaitHealthy(ctx context.Context) error {
+	select {
+	case <-bus.connected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Healthy reports whether this replica is currently receiving invalidations.
+func (bus *invalidationBus) Healthy() bool {
+	return bus.healthy.Load()
+}
+
+func (bus *invalidationBus) Publish(ctx context.Context, op, key string) {
+	msg := bus.instanceID + "|" + op + "|" + key
+	if err := bus.redis.Publish(ctx, invalidationChannel, msg).Err(); err != nil {
+		slog.WarnContext(ctx, "cache invalidation publish failed", "key", key, "err", err)
+	}
+}
+
+// PublishMany sends one message per key in a single round trip.
+func (bus *invalidationBus) PublishMany(ctx context.Context, op string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	pipe := bus.redis.Pipeline()
+	for _, key := range keys {
+		pipe.Publish(ctx, invalidationChannel, bus.instanceID+"|"+op+"|"+key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "cache invalidation publish failed", "keys", len(keys), "err", err)
+	}
+}
+
+func (bus *invalidationBus) Stop() {
+	close(bus.stop)
+	<-bus.done
+}
+
+func (bus *invalidationBus) run() {
+	defer close(bus.done)
+	backoff := time.Second
+	for {
+		err := bus.subscribe()
+		if bus.Healthy() {
+			backoff = time.Second
+		}
+		bus.setHealthy(false)
+		select {
+		case <-bus.stop:
+			return
+		default:
+		}
+		slog.Warn("cache invalidation bus disconnected", "retry_in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-bus.stop:
+			return
+		}
+		backoff = min(backoff*2, busMaxBackoff)
+	}
+}
+
+// subscribe listens until the connection fails or the bus is stopped.
+func (bus *invalidationBus) subscribe() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pubsub := bus.redis.Subscribe(ctx, invalidationChannel)
+	defer pubsub.Close()
+	go func() {
+		select {
+		case <-bus.stop:
+			// A blocked receive only notices when the connection closes.
+			cancel()
+			pubsub.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	lastPong := time.Now()
+	for {
+		msg, err := pubsub.ReceiveTimeout(ctx, busPingInterval)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if !isTimeout(err) {
+				return err
+			}
+			// A quiet channel looks the same as a dead connection, so ping
+			// and give up if the pongs stop coming back.
+			if time.Since(lastPong) > 3*busPingInterval {
+				return errors.New("no reply to pings")
+			}
+			if err := pubsub.Ping(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		lastPong = time.Now()
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			bus.setHealthy(true)
+		case *redis.Message:
+			bus.handle(m.Payload)
+		}
+	}
+}
+
+func (bus *invalidationBus) setHealthy(healthy bool) {
+	was := bus.healthy.Swap(healthy)
+	switch {
+	case healthy && !was:
+		// Anything cached while we were deaf may be stale; it was cached with
+		// the short fallback TTL, but drop it now rather than wait. Creates
+		// we missed aren't in the filter, so it has to be reloaded too.
+		bus.cache.Purge()
+		bus.negative.Purge()
+		bus.filter.Invalidate()
+		go func() {
+			if err := bus.filter.Rebuild(context.Background()); err != nil {
+				slog.Error("code filter rebuild failed", "err", err)
+			}
+		}()
+		bus.firstOnce.Do(func() { close(bus.connected) })
+	case !healthy && was:
+		bus.filter.Invalidate()
+	}
+}
+
+func (bus *invalidationBus) handle(payload string) {
+	sender, rest, ok := strings.Cut(payload, "|")
+	if !ok || sender == bus.instanceID {
+		return
+	}
+	op, key, ok := strings.Cut(rest, "|")
+	if !ok {
+		return
+	}
+	switch op {
+	case "del":
+		bus.cache.Delete(key)
+	case "add":
+		bus.filter.Add(key)
+		bus.negative.Delete(key)
+	}
+}
+
+func isTimeout(err error) bool {
+	type timeout interface{ Timeout() bool }
+	t, ok := err.(timeout)
+	return ok && t.Timeout()
+}
+
+// CacheTTLs are how long each cache layer keeps a link.
+type CacheTTLs struct {
+	// Local is how long a replica trusts its in-memory copy while the bus is
+	// up; Fallback bounds staleness while it is down.
+	Local    time.Duration
+	Fallback time.Duration
+	// Negative is how long a code found missing is remembered.
+	Negative time.Duration
+	Redis    time.Duration
+}
+
+var DefaultCacheTTLs = CacheTTLs{
+	Local:    5 * time.Minute,
+	Fallback: 5 * time.Second,
+	Negative: 30 * time.Second,
+	Redis:    24 * time.Hour,
+}
+
+// SetCacheTTLs changes the TTLs used from now on; entries already cached
+// keep theirs.
+func (URLDB *URLDB) SetCacheTTLs(ttls CacheTTLs) {
+	URLDB.ttls.Store(&ttls)
+}
+
+func (URLDB *URLDB) cacheTTLs() CacheTTLs {
+	if ttls := URLDB.ttls.Load(); ttls != nil {
+		return *ttls
+	}
+	return DefaultCacheTTLs
+}
+
+// localTTL is the TTL for entries in the in-memory cache: short while other
+// replicas can't reach us with invalidations.
+func (URLDB *URLDB) localTTL() time.Duration {
+	ttls := URLDB.cacheTTLs()
+	if URLDB.bus != nil && URLDB.bus.Healthy() {
+		return ttls.Local
+	}
+	return ttls.Fallback
+}
+
+// Invalidate drops short from every replica's local cache. Writes through
+// URLDB call it themselves; it is needed after changing a link in Postgres
+// directly.
+func (URLDB *URLDB) Invalidate(ctx context.Context, short string) {
+	URLDB.Cache.Delete(short)
+	if URLDB.bus != nil {
+		URLDB.bus.Publish(ctx, "del", short)
+	}
+}
@@ -0,0 +1,218 @@
+package Storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+
+	// maxJobIssues caps how many per-row problems are kept on a job.
+	maxJobIssues = 1000
+)
+
+var ErrJobNotFound = customerrors.NotFound("job not found")
+
+type JobIssue struct {
+	Line    int    `json:"line"`
+	Kind    string `json:"kind"`
+	Code    string `json:"code,omitempty"`
+	NewCode string `json:"new_code,omitempty"`
+	Message string `json:"message"`
+}
+
+// A job is leased to the replica running it, which renews the lease while the
+// job is unfinished. Jobs whose lease lapses were abandoned by a replica that
+// stopped.
+type Job struct {
+	ID         int64      `json:"id"`
+	OwnerID    int64      `json:"-"`
+	Kind       string     `json:"kind"`
+	Source     string     `json:"source"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Imported   int        `json:"imported"`
+	Conflicts  int        `json:"conflicts"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	Issues     []JobIssue `json:"issues"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobProgress is the delta a worker reports after each chunk it processes.
+type JobProgress struct {
+	Processed int
+	Imported  int
+	Conflicts int
+	Failed    int
+	Issues    []JobIssue
+}
+
+func (URLDB *URLDB) createJobtable() error {
+	_, err := URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE TABLE IF NOT EXISTS jobs (
+			id BIGSERIAL PRIMARY KEY,
+			owner_id INT REFERENCES users(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			source TEXT NOT NULL,
+			status TEXT NOT NULL,
+			total INT NOT NULL DEFAULT 0,
+			processed INT NOT NULL DEFAULT 0,
+			imported INT NOT NULL DEFAULT 0,
+			conflicts INT NOT NULL DEFAULT 0,
+			failed INT NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			issues JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMPTZ,
+			instance_id TEXT NOT NULL DEFAULT '',
+			lease_renewed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Job table creation error: %w", err)
+	}
+
+	// Jobs created before they had owners stay unreadable rather than
+	// becoming visible to everyone.
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS owner_id INT REFERENCES users(id) ON DELETE CASCADE;
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS instance_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lease_renewed_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+	`)
+	if err != nil {
+		return fmt.Errorf("Job table migration error: %w", err)
+	}
+
+	return nil
+}
+
+// CreateJob records a queued job leased to the replica called instance.
+func (URLDB *URLDB) CreateJob(ctx context.Context, instance string, owner int64, kind, source string) (int64, error) {
+	var id int64
+	err := URLDB.DB.QueryRow(ctx, `
+		INSERT INTO jobs (instance_id, owner_id, kind, source, status) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		instance, owner, kind, source, JobQueued).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("Error creating job: %w", err)
+	}
+	return id, nil
+}
+
+func (URLDB *URLDB) StartJob(ctx context.Context, id int64, total int) error {
+	_, err := URLDB.DB.Exec(ctx, `
+		UPDATE jobs SET status = $2, total = $3, updated_at = NOW() WHERE id = $1`,
+		id, JobRunning, total)
+	if err != nil {
+		return fmt.Errorf("Error starting job: %w", err)
+	}
+	return nil
+}
+
+// AddJobProgress adds the counters in p to the job and appends its issues,
+// keeping at most maxJobIssues of them.
+func (URLDB *URLDB) AddJobProgress(ctx context.Context, id int64, p JobProgress) error {
+	issues, err := json.Marshal(p.Issues)
+	if err != nil {
+		return err
+	}
+	if p.Issues == nil {
+		issues = []byte("[]")
+	}
+	_, err = URLDB.DB.Exec(ctx, `
+		UPDATE jobs SET
+			processed = processed + $2,
+			imported = imported + $3,
+			conflicts = conflicts + $4,
+			failed = failed + $5,
+			issues = CASE
+				WHEN jsonb_array_length(issues) >= $7 THEN issues
+				ELSE issues || (SELECT COALESCE(jsonb_agg(e), '[]')
+				                FROM (SELECT e FROM jsonb_array_elements($6::jsonb) e
+				                      LIMIT $7 - jsonb_array_length(issues)) s)
+			END,
+			updated_at = NOW()
+		WHERE id = $1`,
+		id, p.Processed, p.Imported, p.Conflicts, p.Failed, issues, maxJobIssues)
+	if err != nil {
+		return fmt.Errorf("Error updating job progress: %w", err)
+	}
+	return nil
+}
+
+func (URLDB *URLDB) FinishJob(ctx context.Context, id int64, status string, jobErr string) error {
+	_, err := URLDB.DB.Exec(ctx, `
+		UPDATE jobs SET status = $2, error = $3, updated_at = NOW(), finished_at = NOW()
+		WHERE id = $1`,
+		id, status, jobErr)
+	if err != nil {
+		return fmt.Errorf("Error finishing job: %w", err)
+	}
+	return nil
+}
+
+// RenewJobLeases extends the lease on instance's unfinished jobs.
+func (URLDB *URLDB) RenewJobLeases(ctx context.Context, instance string) error {
+	_, err := URLDB.DB.Exec(ctx, `
+		UPDATE jobs SET lease_renewed_at = NOW()
+		WHERE instance_id = $1 AND status IN ($2, $3)`,
+		instance, JobQueued, JobRunning)
+	if err != nil {
+		return fmt.Errorf("Error renewing job leases: %w", err)
+	}
+	return nil
+}
+
+// FailInterruptedJobs marks unfinished jobs whose lease hasn't been renewed
+// for longer than lease as failed; their input lived in memory on a replica
+// that stopped and can't be resumed. Jobs other replicas are running keep
+// their lease and are left alone.
+func (URLDB *URLDB) FailInterruptedJobs(ctx context.Context, lease time.Duration) (int64, error) {
+	tag, err := URLDB.DB.Exec(ctx, `
+		UPDATE jobs SET status = $1, error = 'interrupted by a server restart',
+			updated_at = NOW(), finished_at = NOW()
+		WHERE status IN ($2, $3) AND lease_renewed_at < NOW() - make_interval(secs => $4)`,
+		JobFailed, JobQueued, JobRunning, lease.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("Error failing interrupted jobs: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetJob returns the owner's job with the given ID. Other owners' jobs are
+// reported as not found, so IDs can't be probed.
+func (URLDB *URLDB) GetJob(ctx context.Context, id, owner int64) (*Job, error) {
+	var job Job
+	var issues []byte
+	err := URLDB.DB.QueryRow(ctx, `
+		SELECT id, owner_id, kind, source, status, total, processed, imported, conflicts, failed,
+			error, issues, created_at, updated_at, finished_at
+		FROM jobs WHERE id = $1 AND owner_id = $2`, id, owner).Scan(
+		&job.ID, &job.OwnerID, &job.Kind, &job.Source, &job.Status, &job.Total, &job.Processed,
+		&job.Imported, &job.Conflicts, &job.Failed, &job.Error, &issues,
+		&job.CreatedAt, &job.UpdatedAt, &job.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching job: %w", err)
+	}
+	if err := json.Unmarshal(issues, &job.Issues); err != nil {
+		return nil, fmt.Errorf("Error decoding job issues: %w", err)
+	}
+	return &job, nil
+}
@@ -0,0 +1,141 @@
+package Storage
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// earlyRefreshWindow is the tail of a local cache entry's life during which
+// reads may trigger a background refresh. The closer the entry is to expiry,
+// the likelier a read is to refresh it, so a hot key is renewed by one request
+// before it expires instead of by all of them after.
+const earlyRefreshWindow = 30 * time.Second
+
+type lookupStats struct {
+	coalesced      atomic.Uint64
+	earlyRefreshes atomic.Uint64
+	negativeHits   atomic.Uint64
+	filterRejects  atomic.Uint64
+	redisHits      atomic.Uint64
+	redisMisses    atomic.Uint64
+	redisErrors    atomic.Uint64
+}
+
+type LookupStats struct {
+	// Coalesced counts lookups that waited for another request's in-flight
+	// fetch of the same code instead of issuing their own.
+	Coalesced      uint64 `json:"coalesced"`
+	EarlyRefreshes uint64 `json:"early_refreshes"`
+	NegativeHits   uint64 `json:"negative_hits"`
+	FilterRejects  uint64 `json:"filter_rejects"`
+	// RedisHits, RedisMisses and RedisErrors count the Redis tier's answers
+	// to lookups the local cache couldn't serve.
+	RedisHits   uint64 `json:"redis_hits"`
+	RedisMisses uint64 `json:"redis_misses"`
+	RedisErrors uint64 `json:"redis_errors"`
+}
+
+func (URLDB *URLDB) LookupStats() LookupStats {
+	return LookupStats{
+		Coalesced:      URLDB.lookupStats.coalesced.Load(),
+		EarlyRefreshes: URLDB.lookupStats.earlyRefreshes.Load(),
+		NegativeHits:   URLDB.lookupStats.negativeHits.Load(),
+		FilterRejects:  URLDB.lookupStats.filterRejects.Load(),
+		RedisHits:      URLDB.lookupStats.redisHits.Load(),
+		RedisMisses:    URLDB.lookupStats.redisMisses.Load(),
+		RedisErrors:    URLDB.lookupStats.redisErrors.Load(),
+	}
+}
+
+func (URLDB *URLDB) shouldRefreshEarly(expiry time.Time) bool {
+	remaining := time.Until(expiry)
+	if remaining >= earlyRefreshWindow {
+		return false
+	}
+	return rand.Float64() >= float64(remaining)/float64(earlyRefreshWindow)
+}
+
+// refreshAsync re-reads short from Redis/Postgres in the background while the
+// caller is served the current value. At most one refresh per code runs at a
+// time.
+func (URLDB *URLDB) refreshAsync(short string) {
+	go func() {
+		URLDB.lookups.Do("refresh:"+short, func() (any, error) {
+			URLDB.lookupStats.earlyRefreshes.Add(1)
+			ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+			defer cancel()
+			return URLDB.fetchURL(ctx, short)
+		})
+	}()
+}
+
+// Scanners probing random codes would otherwise cost a Redis and a Postgres
+// round trip per request. Codes the filter rules out are answered without
+// either; codes that got past it but turned out missing are remembered for
+// the Negative TTL.
+const negativeCacheEntries = 100000
+
+// knownMissing returns ErrNotFound or ErrExpired if short can be answered
+// from memory, nil otherwise. The filter is only trusted while the bus is up,
+// since that is how creates on other replicas reach it.
+func (URLDB *URLDB) knownMissing(short string) error {
+	if URLDB.negative == nil {
+		return nil
+	}
+	if marker, ok := URLDB.negative.Get(short); ok {
+		URLDB.lookupStats.negativeHits.Add(1)
+		if marker == expiredMarker {
+			return ErrExpired
+		}
+		return ErrNotFound
+	}
+	if URLDB.bus.Healthy() && URLDB.filter.Ready() && !URLDB.filter.MayContain(short) {
+		URLDB.lookupStats.filterRejects.Add(1)
+		return ErrNotFound
+	}
+	return nil
+}
+
+// expiredMarker is cached for codes that exist but have expired, so they keep
+// answering ErrExpired rather than ErrNotFound.
+const expiredMarker = "expired"
+
+func (URLDB *URLDB) rememberMissing(short string) {
+	URLDB.rememberNegative(short, "")
+}
+
+func (URLDB *URLDB) rememberExpired(short string) {
+	URLDB.rememberNegative(short, expiredMarker)
+}
+
+func (URLDB *URLDB) rememberNegative(short, marker string) {
+	if URLDB.negative == nil {
+		return
+	}
+	ttls := URLDB.cacheTTLs()
+	ttl := ttls.Negative
+	if !URLDB.bus.Healthy() {
+		ttl = ttls.Fallback
+	}
+	URLDB.negative.Set(short, marker, ttl)
+}
+
+// noteCreated makes this replica aware of new codes right away; the insert
+// path announces them to the others once they are readable from Redis.
+func (URLDB *URLDB) noteCreated(shorts ...string) {
+	if URLDB.negative == nil {
+		return
+	}
+	for _, short := range shorts {
+		URLDB.filter.Add(short)
+		URLDB.negative.Delete(short)
+	}
+}
+
+func (URLDB *URLDB) announceCreated(ctx context.Context, shorts ...string) {
+	if URLDB.bus != nil {
+		URLDB.bus.PublishMany(ctx, "add", shorts)
+	}
+}
@@ -0,0 +1,307 @@
+package Storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Monthly usage is counted in a Redis hash per user and month ("total" plus
+// one "key:<id>" field per API key, 0 for requests made without one) so the
+// check-and-increment is atomic across replicas. Every change is also added
+// to quota_usage, which seeds the hash again if Redis loses it.
+const quotaKeyField = "key:"
+
+// consumeQuotaScript adds ARGV[1] to the total and to field ARGV[3] unless
+// that would pass the limit ARGV[2] (0 means no limit). It returns
+// {-1, 0} when the hash isn't loaded, otherwise {allowed, total}.
+var consumeQuotaScript = redis.NewScript(`
+local total = tonumber(redis.call('HGET', KEYS[1], 'total'))
+if not total then
+	return {-1, 0}
+end
+local n = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+if limit > 0 and total + n > limit then
+	return {0, total}
+end
+total = redis.call('HINCRBY', KEYS[1], 'total', n)
+redis.call('HINCRBY', KEYS[1], ARGV[3], n)
+return {1, total}
+`)
+
+// releaseQuotaScript takes ARGV[1] back from the total and from field
+// ARGV[2], then sets the expiry to ARGV[3]. It leaves a missing hash alone so
+// a release after the key expired or was evicted can't recreate it with
+// negative counts that the next load would keep.
+var releaseQuotaScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HINCRBY', KEYS[1], 'total', -tonumber(ARGV[1]))
+redis.call('HINCRBY', KEYS[1], ARGV[2], -tonumber(ARGV[1]))
+redis.call('EXPIREAT', KEYS[1], ARGV[3])
+return 1
+`)
+
+// consumeAnonQuotaScript adds ARGV[1] to the counter unless that would pass
+// the limit ARGV[2], and sets the expiry to ARGV[3]. It returns
+// {allowed, total}.
+var consumeAnonQuotaScript = redis.NewScript(`
+local total = tonumber(redis.call('GET', KEYS[1]) or '0')
+local n = tonumber(ARGV[1])
+if total + n > tonumber(ARGV[2]) then
+	return {0, total}
+end
+total = redis.call('INCRBY', KEYS[1], n)
+redis.call('EXPIREAT', KEYS[1], ARGV[3])
+return {1, total}
+`)
+
+// releaseAnonQuotaScript takes ARGV[1] back from the counter if it still
+// exists, for the same reason as releaseQuotaScript.
+var releaseAnonQuotaScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('DECRBY', KEYS[1], ARGV[1])
+`)
+
+type KeyUsage struct {
+	APIKeyID int64 `json:"api_key_id"`
+	Links    int64 `json:"links"`
+}
+
+type QuotaUsage struct {
+	Period time.Time  `json:"period"`
+	Links  int64      `json:"links"`
+	Keys   []KeyUsage `json:"keys"`
+}
+
+// QuotaPeriod is the first instant of the month t falls in, in UTC.
+func QuotaPeriod(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func quotaRedisKey(userID int64, period time.Time) string {
+	return fmt.Sprintf("quota:%d:%s", userID, period.Format("2006-01"))
+}
+
+func anonQuotaRedisKey(ip string, period time.Time) string {
+	return fmt.Sprintf("quota:anon:%s:%s", ip, period.Format("2006-01"))
+}
+
+// quotaExpiry is when the hash for period expires: a little past the end of
+// the month so late reads still see it.
+func quotaExpiry(period time.Time) time.Time {
+	return period.AddDate(0, 1, 7)
+}
+
+func (URLDB *URLDB) createQuotatables() error {
+	_, err := URLDB.DB.Exec(URLDB.Ctx, `
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS plan TEXT NOT NULL DEFAULT 'free';
+	`)
+	if err != nil {
+		return fmt.Errorf("User table migration error: %w", err)
+	}
+
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE TABLE IF NOT EXISTS quota_usage (
+			user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			api_key_id BIGINT NOT NULL DEFAULT 0,
+			period DATE NOT NULL,
+			links BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id, period, api_key_id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Quota table creation error: %w", err)
+	}
+
+	return nil
+}
+
+func (URLDB *URLDB) UserPlan(ctx context.Context, userID int64) (string, error) {
+	var plan string
+	err := URLDB.DB.QueryRow(ctx, `SELECT plan FROM users WHERE id = $1`, userID).Scan(&plan)
+	if err != nil {
+		return "", fmt.Errorf("Error fetching user plan: %w", err)
+	}
+	return plan, nil
+}
+
+func (URLDB *URLDB) SetUserPlan(ctx context.Context, userID int64, plan string) error {
+	tag, err := URLDB.DB.Exec(ctx, `UPDATE users SET plan = $2 WHERE id = $1`, userID, plan)
+	if err != nil {
+		return fmt.Errorf("Error updating user plan: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ConsumeQuota counts n links against the user's usage for the current month
+// unless that would pass limit (0 for unlimited). It returns whether the
+// links were counted and the month's total afterwards.
+func (URLDB *URLDB) ConsumeQuota(ctx context.Context, userID, apiKeyID, n, limit int64) (bool, int64, error) {
+	period := QuotaPeriod(time.Now())
+	key := quotaRedisKey(userID, period)
+	field := quotaKeyField + strconv.FormatInt(apiKeyID, 10)
+
+	for range 2 {
+		res, err := consumeQuotaScript.Run(ctx, URLDB.Redis, []string{key}, n, limit, field).Int64Slice()
+		if err != nil {
+			return false, 0, fmt.Errorf("Error checking quota: %w", err)
+		}
+		switch res[0] {
+		case -1:
+			if err := URLDB.loadQuota(ctx, userID, period); err != nil {
+				return false, 0, err
+			}
+			continue
+		case 1:
+			URLDB.persistQuota(userID, apiKeyID, period, n)
+			return true, res[1], nil
+		default:
+			return false, res[1], nil
+		}
+	}
+	return false, 0, fmt.Errorf("Error checking quota: usage for user %d could not be loaded", userID)
+}
+
+// ReleaseQuota gives back n links counted by ConsumeQuota that were not
+// created after all.
+func (URLDB *URLDB) ReleaseQuota(ctx context.Context, userID, apiKeyID, n int64) error {
+	period := QuotaPeriod(time.Now())
+	key := quotaRedisKey(userID, period)
+	field := quotaKeyField + strconv.FormatInt(apiKeyID, 10)
+	// Postgres is updated either way, so a hash loaded later starts from the
+	// released count.
+	err := releaseQuotaScript.Run(ctx, URLDB.Redis, []string{key}, n, field, quotaExpiry(period).Unix()).Err()
+	if err != nil {
+		return fmt.Errorf("Error releasing quota: %w", err)
+	}
+	URLDB.persistQuota(userID, apiKeyID, period, -n)
+	return nil
+}
+
+// ConsumeAnonymousQuota counts n links against the month's usage of callers
+// without an account at ip unless that would pass limit. The count lives in
+// Redis only; there is no user to keep it for in Postgres.
+func (URLDB *URLDB) ConsumeAnonymousQuota(ctx context.Context, ip string, n, limit int64) (bool, int64, error) {
+	period := QuotaPeriod(time.Now())
+	key := anonQuotaRedisKey(ip, period)
+	res, err := consumeAnonQuotaScript.Run(ctx, URLDB.Redis, []string{key}, n, limit, quotaExpiry(period).Unix()).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("Error checking quota: %w", err)
+	}
+	return res[0] == 1, res[1], nil
+}
+
+// ReleaseAnonymousQuota gives back n links counted by ConsumeAnonymousQuota.
+func (URLDB *URLDB) ReleaseAnonymousQuota(ctx context.Context, ip string, n int64) error {
+	key := anonQuotaRedisKey(ip, QuotaPeriod(time.Now()))
+	if err := releaseAnonQuotaScript.Run(ctx, URLDB.Redis, []string{key}, n).Err(); err != nil {
+		return fmt.Errorf("Error releasing quota: %w", err)
+	}
+	return nil
+}
+
+// loadQuota copies the month's usage from Postgres into Redis. HSETNX keeps
+// a replica that loses the race from overwriting counts already made.
+func (URLDB *URLDB) loadQuota(ctx context.Context, userID int64, period time.Time) error {
+	usage, err := URLDB.quotaUsageFromDB(ctx, userID, period)
+	if err != nil {
+		return err
+	}
+	key := quotaRedisKey(userID, period)
+	pipe := URLDB.Redis.TxPipeline()
+	for _, k := range usage.Keys {
+		pipe.HSetNX(ctx, key, quotaKeyField+strconv.FormatInt(k.APIKeyID, 10), k.Links)
+	}
+	pipe.HSetNX(ctx, key, "total", usage.Links)
+	pipe.ExpireAt(ctx, key, quotaExpiry(period))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("Error loading quota: %w", err)
+	}
+	return nil
+}
+
+// persistQuota adds delta to Postgres in the background. Deltas commute, so
+// writes landing out of order still sum correctly.
+func (URLDB *URLDB) persistQuota(userID, apiKeyID int64, period time.Time, delta int64) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := URLDB.DB.Exec(ctx, `
+			INSERT INTO quota_usage (user_id, api_key_id, period, links)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, period, api_key_id)
+			DO UPDATE SET links = quota_usage.links + EXCLUDED.links`,
+			userID, apiKeyID, period, delta)
+		if err != nil {
+			slog.Error("quota persist failed", "user_id", userID, "err", err)
+		}
+	}()
+}
+
+// QuotaUsage returns the user's usage for the month containing period,
+// preferring the live Redis counts.
+func (URLDB *URLDB) QuotaUsage(ctx context.Context, userID int64, period time.Time) (QuotaUsage, error) {
+	period = QuotaPeriod(period)
+	fields, err := URLDB.Redis.HGetAll(ctx, quotaRedisKey(userID, period)).Result()
+	if err != nil || fields["total"] == "" {
+		return URLDB.quotaUsageFromDB(ctx, userID, period)
+	}
+
+	usage := QuotaUsage{Period: period, Keys: []KeyUsage{}}
+	usage.Links, _ = strconv.ParseInt(fields["total"], 10, 64)
+	for field, value := range fields {
+		raw, ok := strings.CutPrefix(field, quotaKeyField)
+		if !ok {
+			continue
+		}
+		id, _ := strconv.ParseInt(raw, 10, 64)
+		links, _ := strconv.ParseInt(value, 10, 64)
+		if links != 0 {
+			usage.Keys = append(usage.Keys, KeyUsage{APIKeyID: id, Links: links})
+		}
+	}
+	sort.Slice(usage.Keys, func(i, j int) bool { return usage.Keys[i].APIKeyID < usage.Keys[j].APIKeyID })
+	return usage, nil
+}
+
+func (URLDB *URLDB) quotaUsageFromDB(ctx context.Context, userID int64, period time.Time) (QuotaUsage, error) {
+	rows, err := URLDB.DB.Query(ctx, `
+		SELECT api_key_id, links FROM quota_usage
+		WHERE user_id = $1 AND period = $2
+		ORDER BY api_key_id`, userID, period)
+	if err != nil {
+		return QuotaUsage{}, fmt.Errorf("Error fetching quota usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := QuotaUsage{Period: period, Keys: []KeyUsage{}}
+	for rows.Next() {
+		var k KeyUsage
+		if err := rows.Scan(&k.APIKeyID, &k.Links); err != nil {
+			return QuotaUsage{}, fmt.Errorf("Error fetching quota usage: %w", err)
+		}
+		usage.Links += k.Links
+		if k.Links != 0 {
+			usage.Keys = append(usage.Keys, k)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return QuotaUsage{}, fmt.Errorf("Error fetching quota usage: %w", err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,71 @@
+package Storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LinkSource is the store of record behind the caches: GetURL falls back to
+// it when Redis misses, and the code filter is loaded from it. ConnectToDB
+// reads Postgres; NewURLDB takes any other.
+type LinkSource interface {
+	// Link returns the destination and expiry of short, or ErrNotFound.
+	Link(ctx context.Context, short string) (string, *time.Time, error)
+	CountCodes(ctx context.Context) (int, error)
+	// ScanCodes calls add for every code, expired ones included: they still
+	// answer 410 and can't be handed out again.
+	ScanCodes(ctx context.Context, add func(string)) error
+}
+
+type postgresSource struct {
+	db *pgxpool.Pool
+}
+
+func (src postgresSource) Link(ctx context.Context, short string) (string, *time.Time, error) {
+	var long string
+	var expiresAt *time.Time
+	err := src.db.QueryRow(ctx, `
+		SELECT long, expires_at FROM urls
+		WHERE short = $1
+		LIMIT 1`, short).Scan(&long, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil, ErrNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("Error fetching url: %w", err)
+	}
+	return long, expiresAt, nil
+}
+
+func (src postgresSource) CountCodes(ctx context.Context) (int, error) {
+	var n int
+	err := src.db.QueryRow(ctx, "SELECT count(*) FROM urls").Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("Error counting urls: %w", err)
+	}
+	return n, nil
+}
+
+func (src postgresSource) ScanCodes(ctx context.Context, add func(string)) error {
+	rows, err := src.db.Query(ctx, "SELECT short FROM urls")
+	if err != nil {
+		return fmt.Errorf("Error loading short codes: %w", err)
+	}
+	defer rows.Close()
+	var short string
+	for rows.Next() {
+		if err := rows.Scan(&short); err != nil {
+			return fmt.Errorf("Error loading short codes: %w", err)
+		}
+		add(short)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error loading short codes: %w", err)
+	}
+	return nil
+}
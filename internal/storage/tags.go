@@ -0,0 +1,329 @@
+package Storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrTagNotFound = customerrors.NotFound("tag not found")
+	ErrTagExists   = customerrors.Conflict("a tag with that name already exists, merge the tags instead")
+)
+
+// insertURLSQL inserts a link ($1 short, $2 long, $3 expires_at, $5 owner)
+// together with its tags ($4). Tags are scoped to the owner and only created
+// and attached when the link row itself was inserted, so a taken code never
+// picks up someone else's tags. It returns the new row's id, or no rows when
+// the code was taken.
+const insertURLSQL = `
+	WITH ins AS (
+		INSERT INTO urls (short, long, expires_at, owner_id)
+		VALUES ($1, $2, $3, $5::int)
+		ON CONFLICT (short) DO NOTHING
+		RETURNING id
+	), new_tags AS (
+		INSERT INTO tags (name, owner_id)
+		SELECT DISTINCT unnest($4::text[]), $5::int WHERE EXISTS (SELECT 1 FROM ins)
+		ON CONFLICT ((COALESCE(owner_id, 0)), name) DO NOTHING
+		RETURNING id
+	), all_tags AS (
+		SELECT id FROM new_tags
+		UNION
+		SELECT id FROM tags
+		WHERE COALESCE(owner_id, 0) = COALESCE($5::int, 0) AND name = ANY($4::text[])
+	), linked AS (
+		INSERT INTO url_tags (url_id, tag_id)
+		SELECT ins.id, all_tags.id FROM ins, all_tags
+		ON CONFLICT DO NOTHING
+	)
+	SELECT id FROM ins`
+
+type Link struct {
+	ID        int64      `json:"-"`
+	Code      string     `json:"code"`
+	LongURL   string     `json:"long_url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Tags      []string   `json:"tags"`
+	Folder    string     `json:"folder,omitempty"`
+}
+
+type TagStat struct {
+	Name   string `json:"name"`
+	Links  int64  `json:"links"`
+	Clicks int64  `json:"clicks"`
+}
+
+func (URLDB *URLDB) createTagtables() error {
+	_, err := URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE TABLE IF NOT EXISTS tags (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			owner_id INT REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Tag table creation error: %w", err)
+	}
+
+	// Tag names are unique per owner; anonymous links share the NULL owner.
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_owner_name ON tags ((COALESCE(owner_id, 0)), name);
+	`)
+	if err != nil {
+		return fmt.Errorf("Index creation error: %w", err)
+	}
+
+	// Lookups by a signed-in owner filter on owner_id itself, which the
+	// expression index above can't serve.
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_tags_owner_id_name ON tags(owner_id, name);
+	`)
+	if err != nil {
+		return fmt.Errorf("Index creation error: %w", err)
+	}
+
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE TABLE IF NOT EXISTS url_tags (
+			url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+			tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (url_id, tag_id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("URL tag table creation error: %w", err)
+	}
+
+	// Listing a tag walks this index newest first without touching urls.
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_url_tags_tag ON url_tags(tag_id, url_id DESC);
+	`)
+	if err != nil {
+		return fmt.Errorf("Index creation error: %w", err)
+	}
+
+	_, err = URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_urls_owner ON urls(owner_id, id DESC) WHERE owner_id IS NOT NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("Index creation error: %w", err)
+	}
+
+	return nil
+}
+
+const linkColumns = `
+	u.id, u.short, u.long, u.created_at, u.expires_at,
+	COALESCE((SELECT array_agg(t.name ORDER BY t.name)
+	          FROM url_tags ut2 JOIN tags t ON t.id = ut2.tag_id
+	          WHERE ut2.url_id = u.id), '{}'),
+	COALESCE((SELECT f.name FROM folders f WHERE f.id = u.folder_id), '')`
+
+// inFolder limits a link listing to the folder named by parameter n, unless
+// it is "". The owner is always $1.
+func inFolder(n int) string {
+	return fmt.Sprintf(`($%[1]d::text = '' OR u.folder_id = (SELECT id FROM folders WHERE owner_id = $1 AND name = $%[1]d))`, n)
+}
+
+// ListLinks returns up to limit of owner's links, newest first, optionally
+// only those tagged tag and only those in folder. Pass the returned cursor
+// back to get the next page; it is 0 once there are no more links.
+func (URLDB *URLDB) ListLinks(ctx context.Context, owner int64, tag, folder string, cursor int64, limit int) ([]Link, int64, error) {
+	if cursor <= 0 {
+		cursor = math.MaxInt64
+	}
+	var rows pgx.Rows
+	var err error
+	if tag == "" {
+		rows, err = URLDB.DB.Query(ctx, `
+			SELECT `+linkColumns+`
+			FROM urls u
+			WHERE u.owner_id = $1 AND u.id < $2 AND `+inFolder(4)+`
+			ORDER BY u.id DESC
+			LIMIT $3`, owner, cursor, limit, folder)
+	} else {
+		rows, err = URLDB.DB.Query(ctx, `
+			SELECT `+linkColumns+`
+			FROM tags t
+			JOIN url_tags ut ON ut.tag_id = t.id
+			JOIN urls u ON u.id = ut.url_id
+			WHERE t.owner_id = $1 AND t.name = $4 AND ut.url_id < $2 AND `+inFolder(5)+`
+			ORDER BY ut.url_id DESC
+			LIMIT $3`, owner, cursor, limit, tag, folder)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error listing links: %w", err)
+	}
+	defer rows.Close()
+
+	links := make([]Link, 0, limit)
+	for rows.Next() {
+		var link Link
+		err := rows.Scan(&link.ID, &link.Code, &link.LongURL, &link.CreatedAt, &link.ExpiresAt, &link.Tags, &link.Folder)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Error listing links: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("Error listing links: %w", err)
+	}
+	var next int64
+	if len(links) == limit {
+		next = links[len(links)-1].ID
+	}
+	return links, next, nil
+}
+
+// SetLinkTags replaces the tags of one of owner's links.
+func (URLDB *URLDB) SetLinkTags(ctx context.Context, owner int64, short string, tags []string) error {
+	tx, err := URLDB.DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Error updating tags: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var urlID int64
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM urls WHERE short = $1 AND owner_id = $2 FOR UPDATE`,
+		short, owner).Scan(&urlID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("Error updating tags: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO tags (name, owner_id)
+		SELECT DISTINCT unnest($1::text[]), $2::int
+		ON CONFLICT ((COALESCE(owner_id, 0)), name) DO NOTHING`,
+		tags, owner)
+	if err != nil {
+		return fmt.Errorf("Error updating tags: %w", err)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM url_tags WHERE url_id = $1`, urlID)
+	if err != nil {
+		return fmt.Errorf("Error updating tags: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO url_tags (url_id, tag_id)
+		SELECT $1, id FROM tags WHERE owner_id = $2 AND name = ANY($3::text[])`,
+		urlID, owner, tags)
+	if err != nil {
+		return fmt.Errorf("Error updating tags: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// LinkOwner returns the owner of short, nil for anonymous links.
+func (URLDB *URLDB) LinkOwner(ctx context.Context, short string) (*int64, error) {
+	var owner *int64
+	err := URLDB.DB.QueryRow(ctx, `SELECT owner_id FROM urls WHERE short = $1`, short).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching link owner: %w", err)
+	}
+	return owner, nil
+}
+
+func (URLDB *URLDB) RenameTag(ctx context.Context, owner int64, from, to string) error {
+	tag, err := URLDB.DB.Exec(ctx, `
+		UPDATE tags SET name = $3 WHERE owner_id = $1 AND name = $2`,
+		owner, from, to)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrTagExists
+	}
+	if err != nil {
+		return fmt.Errorf("Error renaming tag: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// MergeTags moves every link tagged with one of sources onto target, creating
+// target if needed, and deletes the sources.
+func (URLDB *URLDB) MergeTags(ctx context.Context, owner int64, sources []string, target string) error {
+	tx, err := URLDB.DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Error merging tags: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var found int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM tags WHERE owner_id = $1 AND name = ANY($2::text[])`,
+		owner, sources).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("Error merging tags: %w", err)
+	}
+	if found == 0 {
+		return ErrTagNotFound
+	}
+
+	var targetID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO tags (name, owner_id) VALUES ($2, $1)
+		ON CONFLICT ((COALESCE(owner_id, 0)), name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id`, owner, target).Scan(&targetID)
+	if err != nil {
+		return fmt.Errorf("Error merging tags: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO url_tags (url_id, tag_id)
+		SELECT ut.url_id, $3 FROM url_tags ut
+		JOIN tags t ON t.id = ut.tag_id
+		WHERE t.owner_id = $1 AND t.name = ANY($2::text[]) AND t.id <> $3
+		ON CONFLICT DO NOTHING`, owner, sources, targetID)
+	if err != nil {
+		return fmt.Errorf("Error merging tags: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		DELETE FROM tags WHERE owner_id = $1 AND name = ANY($2::text[]) AND id <> $3`,
+		owner, sources, targetID)
+	if err != nil {
+		return fmt.Errorf("Error merging tags: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// TagStats returns every tag of owner with its link count and the total
+// clicks of those links.
+func (URLDB *URLDB) TagStats(ctx context.Context, owner int64) ([]TagStat, error) {
+	rows, err := URLDB.DB.Query(ctx, `
+		SELECT t.name, COUNT(ut.url_id), COALESCE(SUM(c.total), 0)::BIGINT
+		FROM tags t
+		LEFT JOIN url_tags ut ON ut.tag_id = t.id
+		LEFT JOIN LATERAL (
+			SELECT SUM(count) AS total FROM url_clicks WHERE url_id = ut.url_id
+		) c ON TRUE
+		WHERE t.owner_id = $1
+		GROUP BY t.id, t.name
+		ORDER BY t.name`, owner)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching tag stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []TagStat{}
+	for rows.Next() {
+		var stat TagStat
+		if err := rows.Scan(&stat.Name, &stat.Links, &stat.Clicks); err != nil {
+			return nil, fmt.Errorf("Error fetching tag stats: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,89 @@
+package Storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrUserExists     = customerrors.Conflict("username is already taken")
+	ErrUserNotFound   = errors.New("user not found")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+func (URLDB *URLDB) createAPIKeytable() error {
+	_, err := URLDB.DB.Exec(URLDB.Ctx, `
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id BIGSERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			key_hash TEXT UNIQUE NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("API key table creation error: %w", err)
+	}
+
+	return nil
+}
+
+func (URLDB *URLDB) CreateUser(ctx context.Context, username, passwordHash string) (int64, error) {
+	var id int64
+	err := URLDB.DB.QueryRow(ctx, `
+		INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id`,
+		username, passwordHash).Scan(&id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return 0, ErrUserExists
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Error creating user: %w", err)
+	}
+	return id, nil
+}
+
+// UserCredentials returns the ID and password hash of username.
+func (URLDB *URLDB) UserCredentials(ctx context.Context, username string) (int64, string, error) {
+	var id int64
+	var hash string
+	err := URLDB.DB.QueryRow(ctx, `
+		SELECT id, password FROM users WHERE username = $1`, username).Scan(&id, &hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", ErrUserNotFound
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("Error fetching user: %w", err)
+	}
+	return id, hash, nil
+}
+
+func (URLDB *URLDB) CreateAPIKey(ctx context.Context, userID int64, keyHash, name string) (int64, error) {
+	var id int64
+	err := URLDB.DB.QueryRow(ctx, `
+		INSERT INTO api_keys (user_id, key_hash, name) VALUES ($1, $2, $3) RETURNING id`,
+		userID, keyHash, name).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("Error creating API key: %w", err)
+	}
+	return id, nil
+}
+
+// ResolveAPIKey returns the user and key IDs for a hashed API key.
+func (URLDB *URLDB) ResolveAPIKey(ctx context.Context, keyHash string) (int64, int64, error) {
+	var userID, keyID int64
+	err := URLDB.DB.QueryRow(ctx, `
+		SELECT user_id, id FROM api_keys WHERE key_hash = $1`, keyHash).Scan(&userID, &keyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("Error resolving API key: %w", err)
+	}
+	return userID, keyID, nil
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PgxTracer records a client span per query and per batch. Set it as the
+// pool's ConnConfig.Tracer. The SQL is recorded as written, with its $n
+// placeholders, so no argument values reach the spans.
+type PgxTracer struct{}
+
+var (
+	_ pgx.QueryTracer = PgxTracer{}
+	_ pgx.BatchTracer = PgxTracer{}
+)
+
+func (PgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	sql := strings.Join(strings.Fields(data.SQL), " ")
+	ctx, _ = Tracer().Start(ctx, "postgres "+operation(sql), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBQueryText(sql),
+		))
+	return ctx
+}
+
+func (PgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	endSpan(ctx, data.CommandTag.RowsAffected(), data.Err)
+}
+
+func (PgxTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, _ = Tracer().Start(ctx, "postgres batch", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			attribute.Int("db.batch.size", data.Batch.Len()),
+		))
+	return ctx
+}
+
+// TraceBatchQuery adds an event per statement rather than a span, since a
+// batch is a single round trip.
+func (PgxTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	span := trace.SpanFromContext(ctx)
+	span.AddEvent("query", trace.WithAttributes(
+		semconv.DBQueryText(strings.Join(strings.Fields(data.SQL), " ")),
+	))
+	if data.Err != nil {
+		span.RecordError(data.Err)
+	}
+}
+
+func (PgxTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	endSpan(ctx, -1, data.Err)
+}
+
+func endSpan(ctx context.Context, rows int64, err error) {
+	span := trace.SpanFromContext(ctx)
+	if rows >= 0 {
+		span.SetAttributes(attribute.Int64("db.rows_affected", rows))
+	}
+	// No rows is an answer, not a failure.
+	if !errors.Is(err, pgx.ErrNoRows) {
+		RecordError(span, err)
+	}
+	span.End()
+}
+
+// operation is the statement's leading keyword, e.g. SELECT.
+func operation(sql string) string {
+	op, _, _ := strings.Cut(sql, " ")
+	return strings.ToUpper(op)
+}
@@ -1,25 +1,27 @@
 package utils
 
 import (
-	"errors"
 	"net"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync/atomic"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
 )
 
 func isValidURL(raw string) (*url.URL, error) {
 	parsed, err := url.ParseRequestURI(raw)
 	if err != nil {
-		return nil, errors.New("invalid URL format")
+		return nil, customerrors.InvalidURL("invalid URL format")
 	}
 
 	if parsed.Scheme != "http" && parsed.Scheme != "https" {
-		return nil, errors.New("unsupported scheme")
+		return nil, customerrors.InvalidURL("unsupported scheme")
 	}
 
 	if parsed.Host == "" {
-		return nil, errors.New("missing host")
+		return nil, customerrors.InvalidURL("missing host")
 	}
 
 	return parsed, nil
@@ -30,23 +32,56 @@ func isSafeHost(host string) error {
 
 	ips, err := net.LookupIP(hostname)
 	if err != nil {
-		return errors.New("cannot resolve host")
+		return customerrors.InvalidURL("cannot resolve host")
 	}
 
 	for _, ip := range ips {
 		if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() {
-			return errors.New("IP address is unsafe (loopback/private)")
+			return customerrors.UnsafeHost("IP address is unsafe (loopback/private)")
 		}
 	}
 
 	return nil
 }
 
+var blockedDomains atomic.Pointer[map[string]struct{}]
+
+// SetBlockedDomains replaces the domains ValidateURL refuses. Blocking a
+// domain blocks its subdomains too.
+func SetBlockedDomains(domains []string) {
+	set := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			set[domain] = struct{}{}
+		}
+	}
+	blockedDomains.Store(&set)
+}
+
+func isBlockedHost(host string) error {
+	set := blockedDomains.Load()
+	if set == nil || len(*set) == 0 {
+		return nil
+	}
+	hostname := strings.TrimSuffix(strings.ToLower(strings.Split(host, ":")[0]), ".")
+	for {
+		if _, ok := (*set)[hostname]; ok {
+			return customerrors.UnsafeHost("domain is blocked")
+		}
+		_, parent, found := strings.Cut(hostname, ".")
+		if !found {
+			return nil
+		}
+		hostname = parent
+	}
+}
+
 var fastURLRegex = regexp.MustCompile(`^(https?://)([a-zA-Z0-9\-_]+\.)+[a-zA-Z]{2,}(:\d+)?(/.*)?$`)
 
 func isFastValidURL(raw string) error {
 	if !fastURLRegex.MatchString(raw) {
-		return errors.New("URL fails fast regex check")
+		return customerrors.InvalidURL("URL fails fast regex check")
 	}
 	return nil
 }
@@ -61,6 +96,11 @@ func ValidateURL(raw string) error {
 		return err
 	}
 
+	err = isBlockedHost(parsed.Host)
+	if err != nil {
+		return err
+	}
+
 	err = isSafeHost(parsed.Host)
 	if err != nil {
 		return err
@@ -1 +1,136 @@
 package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Log formats accepted by SetupLogger.
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// logLevel is shared by every handler SetupLogger builds, so SetLogLevel
+// takes effect without rebuilding the logger.
+var logLevel = new(slog.LevelVar)
+
+// SetupLogger makes a logger writing to w in format the default for both
+// slog and the standard log package.
+func SetupLogger(w io.Writer, format, level string) error {
+	if err := SetLogLevel(level); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch format {
+	case LogFormatJSON, "":
+		handler = slog.NewJSONHandler(w, opts)
+	case LogFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// ParseLogLevel accepts debug, info, warn and error, in any case.
+func ParseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return l, fmt.Errorf("unknown log level %q", level)
+	}
+	return l, nil
+}
+
+// SetLogLevel changes the minimum level logged from now on. An empty level
+// means info.
+func SetLogLevel(level string) error {
+	if level == "" {
+		level = "info"
+	}
+	l, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(l)
+	return nil
+}
+
+type logFieldsKey struct{}
+
+// logFields collects the attributes added to a request as it passes through
+// the middlewares and handlers.
+type logFields struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// WithLogFields returns a context that AddLogFields can attach attributes
+// to. Every record logged with the context, or one derived from it, carries
+// them.
+func WithLogFields(ctx context.Context, attrs ...slog.Attr) context.Context {
+	if _, ok := ctx.Value(logFieldsKey{}).(*logFields); ok {
+		AddLogFields(ctx, attrs...)
+		return ctx
+	}
+	return context.WithValue(ctx, logFieldsKey{}, &logFields{attrs: attrs})
+}
+
+// AddLogFields attaches attrs to the request's fields. It does nothing for a
+// context not derived from WithLogFields.
+func AddLogFields(ctx context.Context, attrs ...slog.Attr) {
+	fields, ok := ctx.Value(logFieldsKey{}).(*logFields)
+	if !ok {
+		return
+	}
+	fields.mu.Lock()
+	fields.attrs = append(fields.attrs, attrs...)
+	fields.mu.Unlock()
+}
+
+// LogFields returns a copy of the attributes attached to ctx.
+func LogFields(ctx context.Context) []slog.Attr {
+	fields, ok := ctx.Value(logFieldsKey{}).(*logFields)
+	if !ok {
+		return nil
+	}
+	fields.mu.Lock()
+	defer fields.mu.Unlock()
+	return append([]slog.Attr(nil), fields.attrs...)
+}
+
+// LogField returns the value of the field named key attached to ctx.
+func LogField(ctx context.Context, key string) (slog.Value, bool) {
+	for _, attr := range LogFields(ctx) {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return slog.Value{}, false
+}
+
+// contextHandler adds the context's request fields to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		r.AddAttrs(LogFields(ctx)...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1 +1,151 @@
 package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	APIKeyHeader     = "X-API-Key"
+	AdminTokenHeader = "X-Admin-Token"
+	apiKeyPrefix     = "usk_"
+)
+
+var (
+	ErrInvalidAPIKey      = customerrors.New(customerrors.KindUnauthorized, "invalid API key")
+	ErrInvalidCredentials = customerrors.New(customerrors.KindUnauthorized, "invalid username or password")
+)
+
+// Identity is who a request acts on behalf of.
+type Identity struct {
+	UserID   int64
+	APIKeyID int64
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the caller's identity; ok is false for anonymous
+// requests.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func CheckPassword(hash, password string) error {
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// NewAPIKey returns a fresh key to hand to the user and the hash to store.
+// Only the hash is ever persisted.
+func NewAPIKey() (key string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, HashAPIKey(key), nil
+}
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyFromRequest reads the key from the X-API-Key header or an
+// "Authorization: Bearer" header.
+func APIKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return ""
+}
+
+// Resolver maps a hashed API key to an identity, returning ErrInvalidAPIKey
+// for unknown keys.
+type Resolver func(ctx context.Context, keyHash string) (Identity, error)
+
+// Middleware attaches the caller's identity to the request context. Requests
+// without a key pass through anonymously; requests with a bad key are
+// rejected.
+func Middleware(resolve Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := APIKeyFromRequest(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, err := resolve(r.Context(), HashAPIKey(key))
+			if errors.Is(err, ErrInvalidAPIKey) {
+				customerrors.Write(w, r, err)
+				return
+			}
+			if err != nil {
+				customerrors.Write(w, r, &customerrors.Error{
+					Kind: customerrors.KindUnavailable, Message: "failed to check API key", Err: err,
+				})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		})
+	}
+}
+
+// RequireIdentity rejects anonymous requests.
+func RequireIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := FromContext(r.Context()); !ok {
+			customerrors.Write(w, r, customerrors.New(customerrors.KindUnauthorized, "an API key is required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdminToken guards operator endpoints with a shared secret sent in
+// the X-Admin-Token header. With no token configured the endpoints don't
+// exist.
+func RequireAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				customerrors.NotFoundHandler(w, r)
+				return
+			}
+			given := r.Header.Get(AdminTokenHeader)
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				customerrors.Write(w, r, customerrors.New(customerrors.KindUnauthorized, "invalid admin token"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+)
+
+func TestAPIKeys(t *testing.T) {
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "usk_") {
+		t.Errorf("key %q is missing its prefix", key)
+	}
+	if hash != auth.HashAPIKey(key) {
+		t.Error("returned hash doesn't match HashAPIKey")
+	}
+	other, _, _ := auth.NewAPIKey()
+	if other == key {
+		t.Error("two calls returned the same key")
+	}
+}
+
+func TestPasswords(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.CheckPassword(hash, "correct horse"); err != nil {
+		t.Errorf("valid password rejected: %v", err)
+	}
+	if err := auth.CheckPassword(hash, "battery staple"); err != auth.ErrInvalidCredentials {
+		t.Errorf("wrong password: got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	key, hash, _ := auth.NewAPIKey()
+	resolve := func(_ context.Context, keyHash string) (auth.Identity, error) {
+		if keyHash != hash {
+			return auth.Identity{}, auth.ErrInvalidAPIKey
+		}
+		return auth.Identity{UserID: 42, APIKeyID: 1}, nil
+	}
+	var seen *auth.Identity
+	handler := auth.Middleware(resolve)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = nil
+		if id, ok := auth.FromContext(r.Context()); ok {
+			seen = &id
+		}
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		status   int
+		wantUser int64
+	}{
+		{"Anonymous", "", "", http.StatusOK, 0},
+		{"API key header", "X-API-Key", key, http.StatusOK, 42},
+		{"Bearer token", "Authorization", "Bearer " + key, http.StatusOK, 42},
+		{"Unknown key", "X-API-Key", "usk_nope", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.wantUser == 0 && seen != nil {
+				t.Errorf("expected anonymous request, got %+v", *seen)
+			}
+			if tt.wantUser != 0 && (seen == nil || seen.UserID != tt.wantUser) {
+				t.Errorf("identity = %+v, want user %d", seen, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name       string
+		configured string
+		sent       string
+		want       int
+	}{
+		{"no token configured", "", "anything", http.StatusNotFound},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "nope", http.StatusUnauthorized},
+		{"right token", "secret", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/hot-keys", nil)
+			if tt.sent != "" {
+				req.Header.Set(auth.AdminTokenHeader, tt.sent)
+			}
+			rec := httptest.NewRecorder()
+			auth.RequireAdminToken(tt.configured)(ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/config"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(t *testing.T, args ...string) (*config.Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(strings.Builder))
+	return config.Load(fs, args)
+}
+
+// setRequired sets the settings that have no default.
+func setRequired(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("POSTGRES_USER", "app")
+	t.Setenv("POSTGRES_DB", "links")
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	setRequired(t)
+	path := writeFile(t, "config.yaml", `
+server:
+  addr: ":9000"
+  read_timeout: 3s
+postgres:
+  host: file-host
+  port: 5433
+redis:
+  host: file-redis
+`)
+	t.Setenv("POSTGRES_HOST", "env-host")
+	t.Setenv("REDIS_HOST", "env-redis")
+
+	cfg, err := load(t, "-config", path, "-redis.host", "flag-redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Addr != ":9000" || cfg.Server.ReadTimeout != 3*time.Second {
+		t.Errorf("file values not applied: %+v", cfg.Server)
+	}
+	if cfg.Server.WriteTimeout != 10*time.Second {
+		t.Errorf("default write timeout lost: %v", cfg.Server.WriteTimeout)
+	}
+	if cfg.Postgres.Host != "env-host" {
+		t.Errorf("env should override file, got host %q", cfg.Postgres.Host)
+	}
+	if cfg.Postgres.Port != 5433 {
+		t.Errorf("file port not applied, got %d", cfg.Postgres.Port)
+	}
+	if cfg.Redis.Host != "flag-redis" {
+		t.Errorf("flag should override env, got redis host %q", cfg.Redis.Host)
+	}
+}
+
+func TestLoadSecretFromFile(t *testing.T) {
+	setRequired(t)
+	t.Setenv("POSTGRES_PASSWORD", "from-env")
+	t.Setenv("POSTGRES_PASSWORD_FILE", writeFile(t, "password", "s3cr@t/pw\n"))
+
+	cfg, err := load(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Postgres.Password != "s3cr@t/pw" {
+		t.Fatalf("password = %q, want the file's contents without the newline", cfg.Postgres.Password)
+	}
+	if dsn := cfg.Postgres.DSN(); !strings.Contains(dsn, "app:s3cr%40t%2Fpw@") {
+		t.Errorf("password not escaped in DSN: %s", dsn)
+	}
+}
+
+func TestLoadInvalidEnvValue(t *testing.T) {
+	setRequired(t)
+	t.Setenv("POSTGRES_PORT", "five")
+
+	_, err := load(t)
+	if err == nil || !strings.Contains(err.Error(), `postgres.port: invalid value "five" from POSTGRES_PORT`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadTracingFromEnv(t *testing.T) {
+	setRequired(t)
+	t.Setenv("TRACING_EXPORTER", "otlp")
+	t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+
+	cfg, err := load(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tracing.Exporter != "otlp" || cfg.Tracing.SampleRatio != 0.25 {
+		t.Errorf("tracing = %+v", cfg.Tracing)
+	}
+
+	t.Setenv("TRACING_SAMPLE_RATIO", "2")
+	if _, err := load(t); err == nil || !strings.Contains(err.Error(), "tracing.sample_ratio") {
+		t.Fatalf("expected a sample ratio error, got %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownFileKey(t *testing.T) {
+	setRequired(t)
+	path := writeFile(t, "config.yaml", "postgres:\n  hots: db\n")
+
+	_, err := load(t, "-config", path)
+	if err == nil || !strings.Contains(err.Error(), "hots") {
+		t.Fatalf("expected an error naming the unknown key, got %v", err)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := config.Default()
+	cfg.Postgres.User = "app"
+	cfg.Postgres.Database = "links"
+	cfg.Redis.Port = 0
+	cfg.ShortCodes.Length = 20
+	cfg.RateLimits[0].Rate = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"redis.port", "short_codes.length", "rate_limits"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %s: %v", want, err)
+		}
+	}
+}
+
+func TestDefaultsNeedDatabaseSettings(t *testing.T) {
+	if err := config.Default().Validate(); err == nil || !strings.Contains(err.Error(), "postgres.user") {
+		t.Fatalf("expected missing postgres.user, got %v", err)
+	}
+}
+
+func TestLoadRateLimitsFromFile(t *testing.T) {
+	setRequired(t)
+	path := writeFile(t, "config.yaml", `
+rate_limits:
+  - name: redirect
+    rate: 100
+    window: 1m
+    algorithm: gcra
+    key_by: [ip]
+    on_failure: open
+`)
+	cfg, err := load(t, "-config", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.RateLimits) != 1 {
+		t.Fatalf("got %d policies, want the file's list to replace the defaults", len(cfg.RateLimits))
+	}
+	p := cfg.RateLimits[0]
+	if p.Rate != 100 || p.Window != time.Minute || p.OnFailure != "open" {
+		t.Errorf("policy not decoded: %+v", p)
+	}
+}
@@ -0,0 +1,81 @@
+package config_test
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/config"
+)
+
+func newWatcher(t *testing.T, path string) *config.Watcher {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	loader, err := config.NewLoader(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.NewWatcher(loader, cfg)
+}
+
+func TestWatcherAppliesReloadableSettings(t *testing.T) {
+	setRequired(t)
+	path := writeFile(t, "config.yaml", "server:\n  addr: \":9000\"\n")
+	w := newWatcher(t, path)
+
+	var applied *config.Config
+	w.OnReload(func(cfg *config.Config) { applied = cfg })
+
+	err := os.WriteFile(path, []byte(`
+server:
+  addr: ":9100"
+cache:
+  negative_ttl: 1m
+blocked_domains: [evil.example]
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := w.Current()
+	if applied != cfg {
+		t.Fatal("callback didn't get the current configuration")
+	}
+	if cfg.Cache.NegativeTTL != time.Minute || len(cfg.BlockedDomains) != 1 {
+		t.Errorf("reloadable settings not applied: %+v, %v", cfg.Cache, cfg.BlockedDomains)
+	}
+	if cfg.Server.Addr != ":9000" {
+		t.Errorf("server.addr needs a restart but changed to %q", cfg.Server.Addr)
+	}
+}
+
+func TestWatcherRejectsInvalidConfig(t *testing.T) {
+	setRequired(t)
+	path := writeFile(t, "config.yaml", "blocked_domains: [a.example]\n")
+	w := newWatcher(t, path)
+	before := w.Current()
+
+	calls := 0
+	w.OnReload(func(*config.Config) { calls++ })
+
+	err := os.WriteFile(path, []byte("blocked_domains: [b.example]\ncache:\n  local_ttl: -1s\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Reload()
+	if err == nil || !strings.Contains(err.Error(), "cache.local_ttl") {
+		t.Fatalf("expected the invalid TTL to be reported, got %v", err)
+	}
+	if w.Current() != before || calls != 0 {
+		t.Error("running configuration changed after a rejected reload")
+	}
+}
@@ -0,0 +1,117 @@
+package customerrors_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+func write(err error) (*httptest.ResponseRecorder, map[string]any) {
+	handler := middlewares.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		customerrors.Write(w, r, err)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/abc", nil)
+	req.Header.Set(middlewares.RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var body map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec, body
+}
+
+func TestWriteStatuses(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{Storage.ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("Error getting URL: %w", Storage.ErrExpired), http.StatusGone},
+		{customerrors.InvalidURL("invalid URL format"), http.StatusUnprocessableEntity},
+		{customerrors.UnsafeHost("domain is blocked"), http.StatusUnprocessableEntity},
+		{Storage.ErrTagExists, http.StatusConflict},
+		{customerrors.Invalidf("missing long_url parameter"), http.StatusBadRequest},
+		{Storage.ErrShuttingDown, http.StatusServiceUnavailable},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		rec, body := write(tt.err)
+		if rec.Code != tt.want {
+			t.Errorf("%v: status %d, want %d", tt.err, rec.Code, tt.want)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != customerrors.ProblemContentType {
+			t.Errorf("%v: content type %q", tt.err, ct)
+		}
+		if body["status"] != float64(tt.want) || body["instance"] != "/api/abc" || body["request_id"] != "req-1" {
+			t.Errorf("%v: unexpected body %v", tt.err, body)
+		}
+		if typ, _ := body["type"].(string); typ == "" || body["title"] == "" {
+			t.Errorf("%v: missing type or title in %v", tt.err, body)
+		}
+	}
+}
+
+func TestWriteHidesInternalCauses(t *testing.T) {
+	cause := errors.New("pq: password authentication failed for user \"app\"")
+	for _, err := range []error{cause, customerrors.Internal(cause, "failed to list links")} {
+		rec, body := write(err)
+		if strings.Contains(rec.Body.String(), "password") {
+			t.Errorf("response leaks the cause: %s", rec.Body.String())
+		}
+		if body["detail"] == "" {
+			t.Errorf("no detail in %v", body)
+		}
+	}
+
+	// Wrapping a typed error keeps its kind.
+	rec, _ := write(customerrors.Internal(fmt.Errorf("lookup: %w", Storage.ErrNotFound), "failed to resolve URL"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", rec.Code)
+	}
+}
+
+func TestWriteRetryAfterAndFields(t *testing.T) {
+	rec, body := write(&customerrors.Error{
+		Kind:       customerrors.KindQuotaExceeded,
+		Message:    "monthly quota used up",
+		RetryAfter: 1500 * time.Millisecond,
+		Fields:     map[string]any{"limit": 100},
+	})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if body["limit"] != float64(100) || body["detail"] != "monthly quota used up" {
+		t.Errorf("unexpected body %v", body)
+	}
+}
+
+func TestInvalidRequestKeepsTypedErrors(t *testing.T) {
+	tooMany := customerrors.Newf(customerrors.KindTooLarge, "too many links, the limit is %d", 10)
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("unexpected EOF"), http.StatusBadRequest},
+		{fmt.Errorf("reading CSV: %w", tooMany), http.StatusRequestEntityTooLarge},
+		{&http.MaxBytesError{Limit: 1 << 20}, http.StatusRequestEntityTooLarge},
+		{Storage.ErrNotFound, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec, _ := write(customerrors.InvalidRequest(tt.err))
+		if rec.Code != tt.want {
+			t.Errorf("%v: status %d, want %d", tt.err, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package export_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/export"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/parquet-go/parquet-go"
+)
+
+func sampleRows() []Storage.ExportRow {
+	owner := int64(7)
+	clicks := int64(42)
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []Storage.ExportRow{
+		{
+			Code:        "abc1234",
+			Destination: "https://example.com/a",
+			OwnerID:     &owner,
+			CreatedAt:   time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+			ExpiresAt:   &expires,
+			Clicks:      &clicks,
+		},
+		{
+			Code:        "xyz9876",
+			Destination: "https://example.com/b,with,commas",
+			CreatedAt:   time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC),
+			Expired:     true,
+		},
+	}
+}
+
+func writeAll(t *testing.T, format string, withClicks bool) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := export.NewWriter(format, &buf, withClicks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range sampleRows() {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestCSVExport(t *testing.T) {
+	records, err := csv.NewReader(writeAll(t, export.FormatCSV, true)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want header + 2", len(records))
+	}
+	if got := records[0][len(records[0])-1]; got != "clicks" {
+		t.Errorf("last header column = %q, want clicks", got)
+	}
+	if got := records[1]; got[2] != "7" || got[4] != "2030-01-02T03:04:05Z" || got[6] != "42" {
+		t.Errorf("unexpected first row %v", got)
+	}
+	if got := records[2]; got[1] != "https://example.com/b,with,commas" || got[5] != "true" || got[6] != "0" {
+		t.Errorf("unexpected second row %v", got)
+	}
+}
+
+func TestNDJSONExport(t *testing.T) {
+	scanner := bufio.NewScanner(writeAll(t, export.FormatNDJSON, false))
+	var rows []Storage.ExportRow
+	for scanner.Scan() {
+		var row Storage.ExportRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 2 || rows[0].Code != "abc1234" || *rows[0].OwnerID != 7 || rows[1].OwnerID != nil {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+}
+
+func TestParquetExport(t *testing.T) {
+	buf := writeAll(t, export.FormatParquet, true)
+	rows, err := parquet.Read[Storage.ExportRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	want := sampleRows()
+	if rows[0].Code != want[0].Code || *rows[0].Clicks != 42 || !rows[0].ExpiresAt.Equal(*want[0].ExpiresAt) {
+		t.Errorf("unexpected first row %+v", rows[0])
+	}
+	if rows[1].ExpiresAt != nil || rows[1].Clicks != nil || !rows[1].Expired {
+		t.Errorf("unexpected second row %+v", rows[1])
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := export.NewWriter("xml", &bytes.Buffer{}, false); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,52 @@
+package handlers_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+)
+
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		alias       string
+		expectError bool
+	}{
+		{"summer-24", false},
+		{"Promo_1", false},
+		{"", true},
+		{"has space", true},
+		{"slash/es", true},
+		{"much-too-long", true},
+		{"links", true},
+		{"Export", true},
+	}
+	for _, tt := range tests {
+		err := handlers.ValidateAlias(tt.alias)
+		if (err != nil) != tt.expectError {
+			t.Errorf("ValidateAlias(%q) error = %v, expectError %v", tt.alias, err, tt.expectError)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := handlers.NormalizeTags([]string{" Campaign ", "campaign", "", "Q3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"campaign", "q3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeTags = %v, want %v", got, want)
+	}
+
+	if _, err := handlers.NormalizeTags([]string{strings.Repeat("x", 65)}); err == nil {
+		t.Error("expected error for an over-long tag")
+	}
+	many := make([]string, 21)
+	for i := range many {
+		many[i] = strings.Repeat("t", i+1)
+	}
+	if _, err := handlers.NormalizeTags(many); err == nil {
+		t.Error("expected error for too many tags")
+	}
+}
@@ -0,0 +1,26 @@
+package handlers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+)
+
+func TestValidateFolderName(t *testing.T) {
+	got, err := handlers.ValidateFolderName("  Q3 Campaigns ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Q3 Campaigns" {
+		t.Errorf("ValidateFolderName = %q, want case kept and spaces trimmed", got)
+	}
+	for _, name := range []string{"", "   ", strings.Repeat("x", 65)} {
+		if _, err := handlers.ValidateFolderName(name); err == nil {
+			t.Errorf("ValidateFolderName(%q): expected an error", name)
+		}
+	}
+	if err := handlers.ValidateAlias("folders"); err == nil {
+		t.Error("expected the folders route to be a reserved alias")
+	}
+}
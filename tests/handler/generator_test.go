@@ -0,0 +1,228 @@
+package handlers_test
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+)
+
+func TestGeneratorsAlphabetAndLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		gen      handlers.CodeGenerator
+		alphabet string
+		length   int
+	}{
+		{"Random default", &handlers.RandomGenerator{Alphabet: handlers.Alphabet, Length: 7}, handlers.Alphabet, 7},
+		{"Random safe alphabet", &handlers.RandomGenerator{Alphabet: handlers.SafeAlphabet, Length: 9}, handlers.SafeAlphabet, 9},
+		{"Hash", &handlers.HashGenerator{Alphabet: handlers.Alphabet, Length: 7, Salt: "s"}, handlers.Alphabet, 7},
+		{"Sequence", handlers.NewSequenceGenerator(handlers.SafeAlphabet, 6, "salt", nil), handlers.SafeAlphabet, 6},
+		{"Words", &handlers.WordGenerator{Length: 8}, "bdfghjklmnprstvzaeiou", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range 1000 {
+				code, err := tt.gen.Generate(fmt.Sprintf("https://example.com/%d", i), 0)
+				if err != nil {
+					t.Fatalf("Generate returned error: %v", err)
+				}
+				if len(code) != tt.length {
+					t.Fatalf("code %q has length %d, want %d", code, len(code), tt.length)
+				}
+				for _, ch := range code {
+					if !strings.ContainsRune(tt.alphabet, ch) {
+						t.Fatalf("code %q contains %q outside the alphabet", code, ch)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSafeAlphabetHasNoAmbiguousCharacters(t *testing.T) {
+	for _, ch := range "0OoIl1" {
+		if strings.ContainsRune(handlers.SafeAlphabet, ch) {
+			t.Errorf("SafeAlphabet contains ambiguous character %q", ch)
+		}
+	}
+}
+
+func TestGeneratorRejectsBadParams(t *testing.T) {
+	gens := []handlers.CodeGenerator{
+		&handlers.RandomGenerator{Alphabet: handlers.Alphabet, Length: 0},
+		&handlers.RandomGenerator{Alphabet: handlers.Alphabet, Length: handlers.MaxCodeLength + 1},
+		&handlers.RandomGenerator{Alphabet: "a", Length: 5},
+		&handlers.HashGenerator{Alphabet: handlers.Alphabet, Length: 11},
+		&handlers.WordGenerator{Length: 0},
+	}
+	for _, gen := range gens {
+		if _, err := gen.Generate("https://example.com", 0); err == nil {
+			t.Errorf("%T%+v: expected error, got none", gen, gen)
+		}
+	}
+}
+
+func TestHashGeneratorIsDeterministic(t *testing.T) {
+	gen := &handlers.HashGenerator{Alphabet: handlers.Alphabet, Length: 7, Salt: "pepper"}
+	a, _ := gen.Generate("https://example.com/a", 0)
+	b, _ := gen.Generate("https://example.com/a", 0)
+	if a != b {
+		t.Errorf("same URL produced %q and %q", a, b)
+	}
+	retry, _ := gen.Generate("https://example.com/a", 1)
+	if retry == a {
+		t.Errorf("retry attempt produced the same code %q", a)
+	}
+	other := &handlers.HashGenerator{Alphabet: handlers.Alphabet, Length: 7, Salt: "salt"}
+	if c, _ := other.Generate("https://example.com/a", 0); c == a {
+		t.Errorf("different salts produced the same code %q", a)
+	}
+}
+
+func TestGeneratorCollisionRates(t *testing.T) {
+	const n = 100000
+	tests := []struct {
+		name    string
+		gen     handlers.CodeGenerator
+		maxRate float64
+	}{
+		{"Random", &handlers.RandomGenerator{Alphabet: handlers.Alphabet, Length: 7}, 0.0001},
+		{"Hash", &handlers.HashGenerator{Alphabet: handlers.Alphabet, Length: 7}, 0.0001},
+		{"Sequence", handlers.NewSequenceGenerator(handlers.Alphabet, 7, "salt", nil), 0.0001},
+		// 16*5 syllables, four per code: ~41M codes, so some collisions are expected.
+		{"Words", &handlers.WordGenerator{Length: 8}, 0.005},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[string]struct{}, n)
+			collisions := 0
+			for i := range n {
+				code, err := tt.gen.Generate(fmt.Sprintf("https://example.com/%d", i), 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := seen[code]; ok {
+					collisions++
+				}
+				seen[code] = struct{}{}
+			}
+			if rate := float64(collisions) / n; rate > tt.maxRate {
+				t.Errorf("collision rate %.5f exceeds %.5f", rate, tt.maxRate)
+			}
+		})
+	}
+}
+
+func TestSequenceGeneratorIsABijection(t *testing.T) {
+	// 3^6 = 729 codes, which the Feistel network has to cycle-walk into.
+	const space = 729
+	gen := handlers.NewSequenceGenerator("abc", 6, "salt", nil)
+	seen := make(map[string]struct{}, space)
+	for range space {
+		code, err := gen.Generate("", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := seen[code]; ok {
+			t.Fatalf("code %q handed out twice", code)
+		}
+		seen[code] = struct{}{}
+	}
+	if _, err := gen.Generate("", 0); err == nil {
+		t.Error("expected an error once the code space is used up")
+	}
+}
+
+func TestSequenceGeneratorUsesSharedCounter(t *testing.T) {
+	var counter uint64
+	next := func() (uint64, error) {
+		counter++
+		return counter, nil
+	}
+	// Two replicas with the same salt drawing from one counter never clash.
+	a := handlers.NewSequenceGenerator(handlers.Alphabet, 7, "salt", next)
+	b := handlers.NewSequenceGenerator(handlers.Alphabet, 7, "salt", next)
+	seen := map[string]struct{}{}
+	for i := range 1000 {
+		gen := a
+		if i%2 == 1 {
+			gen = b
+		}
+		code, err := gen.Generate("", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := seen[code]; ok {
+			t.Fatalf("code %q handed out twice", code)
+		}
+		seen[code] = struct{}{}
+	}
+
+	failing := handlers.NewSequenceGenerator(handlers.Alphabet, 7, "salt", func() (uint64, error) {
+		return 0, fmt.Errorf("connection refused")
+	})
+	if _, err := failing.Generate("", 0); err == nil {
+		t.Error("expected the counter's error")
+	}
+}
+
+// TestGeneratorDistribution runs a chi-squared test on the character
+// frequencies of every position.
+func TestGeneratorDistribution(t *testing.T) {
+	const n = 50000
+	tests := []struct {
+		name string
+		gen  handlers.CodeGenerator
+	}{
+		{"Random", &handlers.RandomGenerator{Alphabet: handlers.Alphabet, Length: 7}},
+		{"Hash", &handlers.HashGenerator{Alphabet: handlers.Alphabet, Length: 7}},
+	}
+	alphabet := []rune(handlers.Alphabet)
+	index := make(map[rune]int, len(alphabet))
+	for i, ch := range alphabet {
+		index[ch] = i
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := make([][]int, 7)
+			for i := range counts {
+				counts[i] = make([]int, len(alphabet))
+			}
+			for i := range n {
+				code, _ := tt.gen.Generate(fmt.Sprintf("https://example.com/%d", i), 0)
+				for pos, ch := range []rune(code) {
+					counts[pos][index[ch]]++
+				}
+			}
+			expected := float64(n) / float64(len(alphabet))
+			// 61 degrees of freedom; 115 is past the 0.001% critical value.
+			const critical = 115.0
+			for pos, row := range counts {
+				chi := 0.0
+				for _, c := range row {
+					chi += math.Pow(float64(c)-expected, 2) / expected
+				}
+				if chi > critical {
+					t.Errorf("position %d: chi-squared %.1f exceeds %.1f", pos, chi, critical)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratorByName(t *testing.T) {
+	for _, name := range []string{handlers.StrategyRandom, handlers.StrategyHash, handlers.StrategySequence, handlers.StrategyWords} {
+		if _, err := handlers.GeneratorByName(name); err != nil {
+			t.Errorf("GeneratorByName(%q): %v", name, err)
+		}
+	}
+	if _, err := handlers.GeneratorByName("nope"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+	if err := handlers.SetDefaultGenerator("nope"); err == nil {
+		t.Error("expected error setting unknown default strategy")
+	}
+}
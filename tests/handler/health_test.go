@@ -0,0 +1,79 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/routes"
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+func healthRouter(health *handlers.Health) *chi.Mux {
+	router := chi.NewRouter()
+	routes.HealthRoutes(router, health)
+	return router
+}
+
+func probe(t *testing.T, router http.Handler, path string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return rec.Code, body
+}
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	// Neither probe below reaches the dependencies.
+	health := handlers.NewHealth(&Storage.URLDB{}, "test")
+	health.SetDraining()
+	router := healthRouter(health)
+
+	if code, _ := probe(t, router, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d while draining, want 200", code)
+	}
+	code, body := probe(t, router, "/readyz")
+	if code != http.StatusServiceUnavailable || body["draining"] != true {
+		t.Errorf("/readyz = %d %v, want 503 and draining", code, body)
+	}
+}
+
+// Needs a real Postgres and Redis, like the storage benchmarks.
+func TestReadinessWithDependencies(t *testing.T) {
+	pg := os.Getenv("STORAGE_TEST_POSTGRES_URL")
+	rdb := os.Getenv("STORAGE_TEST_REDIS_ADDR")
+	if pg == "" || rdb == "" {
+		t.Skip("STORAGE_TEST_POSTGRES_URL and STORAGE_TEST_REDIS_ADDR not set")
+	}
+	DB, err := Storage.ConnectToDB(pg, rdb, Storage.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DB.Close()
+	router := healthRouter(handlers.NewHealth(DB, "test"))
+
+	code, body := probe(t, router, "/readyz")
+	if code != http.StatusOK || body["ready"] != true {
+		t.Fatalf("/readyz = %d %v", code, body)
+	}
+	code, body = probe(t, router, "/status")
+	if code != http.StatusOK {
+		t.Fatalf("/status = %d %v", code, body)
+	}
+	deps, _ := body["dependencies"].(map[string]any)
+	for _, name := range []string{"postgres", "redis"} {
+		if _, ok := deps[name]; !ok {
+			t.Errorf("/status is missing %s", name)
+		}
+	}
+	if build, _ := body["build"].(map[string]any); build["version"] != "test" {
+		t.Errorf("build = %v", body["build"])
+	}
+}
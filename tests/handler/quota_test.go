@@ -0,0 +1,29 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	customerrors "github.com/Moukhtar-youssef/URL_Shortner.git/internal/custom_errors"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+)
+
+func TestQuotaExceededKind(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	for name, plan := range handlers.Plans {
+		err := handlers.QuotaExceeded(plan, plan.MonthlyLinks, reset)
+		want := customerrors.KindQuotaExceeded
+		if name == handlers.PlanFree {
+			want = customerrors.KindUpgradeRequired
+		}
+		if err.Kind != want {
+			t.Errorf("%s: Kind = %s, want %s", name, err.Kind, want)
+		}
+		if err.Fields["plan"] != name {
+			t.Errorf("%s: plan field = %v", name, err.Fields["plan"])
+		}
+	}
+	if free := handlers.Plans[handlers.PlanFree]; free.MonthlyLinks != 1000 {
+		t.Errorf("free plan allows %d links a month, want 1000", free.MonthlyLinks)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
 )
 
 func TestShortner(t *testing.T) {
@@ -58,3 +59,30 @@ func TestShortner(t *testing.T) {
 		})
 	}
 }
+
+func TestShortnerBlockedDomain(t *testing.T) {
+	utils.SetBlockedDomains([]string{"Evil.example"})
+	t.Cleanup(func() { utils.SetBlockedDomains(nil) })
+
+	for _, longurl := range []string{
+		"https://evil.example/login",
+		"https://www.evil.example:8443/",
+		"https://EVIL.Example/path",
+	} {
+		if _, err := handlers.Shortner(longurl); err == nil || err.Error() != "domain is blocked" {
+			t.Errorf("Shortner(%q) error = %v, want domain is blocked", longurl, err)
+		}
+	}
+}
+
+func TestEditLongURLValidatesDestination(t *testing.T) {
+	utils.SetBlockedDomains([]string{"evil.example"})
+	t.Cleanup(func() { utils.SetBlockedDomains(nil) })
+
+	// Rejected before storage is touched, so no database is needed.
+	for _, longurl := range []string{"https://evil.example/login", "not a url", ""} {
+		if _, err := handlers.EditLongURL(nil, "abc", longurl); err == nil {
+			t.Errorf("EditLongURL(%q) succeeded, want a validation error", longurl)
+		}
+	}
+}
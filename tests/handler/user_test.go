@@ -0,0 +1,52 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/handlers"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+)
+
+func TestCachedResolverLooksUpEachKeyOnce(t *testing.T) {
+	lookups := map[string]int{}
+	fail := false
+	resolve := handlers.CachedResolver(func(ctx context.Context, keyHash string) (auth.Identity, error) {
+		lookups[keyHash]++
+		if fail {
+			return auth.Identity{}, errors.New("connection refused")
+		}
+		if keyHash == "good" {
+			return auth.Identity{UserID: 7, APIKeyID: 3}, nil
+		}
+		return auth.Identity{}, auth.ErrInvalidAPIKey
+	})
+	ctx := context.Background()
+
+	for range 5 {
+		id, err := resolve(ctx, "good")
+		if err != nil || id != (auth.Identity{UserID: 7, APIKeyID: 3}) {
+			t.Fatalf("resolve(good) = %+v, %v", id, err)
+		}
+		if _, err := resolve(ctx, "made-up"); !errors.Is(err, auth.ErrInvalidAPIKey) {
+			t.Fatalf("resolve(made-up) = %v, want ErrInvalidAPIKey", err)
+		}
+	}
+	if lookups["good"] != 1 || lookups["made-up"] != 1 {
+		t.Errorf("lookups = %v, want one per key", lookups)
+	}
+
+	// Outages aren't remembered, so the key works again once they end.
+	fail = true
+	if _, err := resolve(ctx, "other"); err == nil {
+		t.Fatal("expected the lookup error")
+	}
+	fail = false
+	if _, err := resolve(ctx, "other"); !errors.Is(err, auth.ErrInvalidAPIKey) {
+		t.Errorf("resolve(other) after the outage = %v", err)
+	}
+	if lookups["other"] != 2 {
+		t.Errorf("failed lookup was cached: %d lookups", lookups["other"])
+	}
+}
@@ -0,0 +1,84 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/metrics"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+)
+
+func scrape(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape status = %d", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestHTTPMetricsUseRoutePattern(t *testing.T) {
+	reg := metrics.NewRegistry()
+	router := chi.NewRouter()
+	router.Use(metrics.NewHTTPMetrics(reg).Middleware)
+	router.Get("/api/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	for _, path := range []string{"/api/abc", "/api/def", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	out := scrape(t, metrics.Handler(reg))
+	for _, want := range []string{
+		`urlshortner_http_requests_total{method="GET",route="/api/{id}",status="404"} 2`,
+		`urlshortner_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`urlshortner_http_request_duration_seconds_count{method="GET",route="/api/{id}",status="404"} 2`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape is missing %s", want)
+		}
+	}
+	if strings.Contains(out, "/api/abc") {
+		t.Error("scrape has a series per path")
+	}
+}
+
+func TestRateLimitMetrics(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rls, err := middlewares.NewRateLimiters(mr.Addr(), []middlewares.Policy{{
+		Name: "create", Rate: 1, Window: time.Minute,
+		Algorithm: middlewares.SlidingLog, KeyBy: []string{middlewares.KeyByIP},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rls.Close()
+	reg := metrics.NewRegistry()
+	metrics.RegisterRateLimits(reg, rls)
+
+	handler := rls.Middleware("create")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/create", nil))
+	}
+
+	out := scrape(t, metrics.Handler(reg))
+	for _, want := range []string{
+		`urlshortner_rate_limit_decisions_total{policy="create",result="allowed"} 1`,
+		`urlshortner_rate_limit_decisions_total{policy="create",result="rejected"} 2`,
+		"urlshortner_rate_limit_breaker_open 0",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape is missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package middlewares_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+)
+
+func TestFailureModes(t *testing.T) {
+	tests := []struct {
+		mode        middlewares.FailureMode
+		wantAllowed int
+		wantErr     bool
+	}{
+		{middlewares.FailOpen, 5, false},
+		{middlewares.FailClosed, 0, true},
+		{middlewares.FailLocal, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			rl, mr := newLimiter(t, 2, time.Minute, middlewares.GCRA)
+			rl.WithFailureMode(tt.mode)
+			mr.SetError("ERR server unavailable")
+
+			allowed := 0
+			for range 5 {
+				d, err := rl.Allow(context.Background(), "client")
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+				}
+				if d.Allowed {
+					allowed++
+				}
+			}
+			if allowed != tt.wantAllowed {
+				t.Errorf("allowed %d of 5 during the outage, want %d", allowed, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestBreakerSkipsRedisAndRecovers(t *testing.T) {
+	rl, mr := newLimiter(t, 100, time.Minute, middlewares.SlidingLog)
+	rl.WithBreaker(middlewares.BreakerOptions{FailureThreshold: 3, Cooldown: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	mr.SetError("ERR connection reset")
+	for range 10 {
+		if d, _ := rl.Allow(ctx, "client"); !d.Allowed {
+			t.Fatal("local fallback rejected a request under the limit")
+		}
+	}
+	stats := rl.Stats()
+	if !stats.BreakerOpen || stats.BreakerTrips != 1 {
+		t.Fatalf("breaker not open after repeated errors: %+v", stats)
+	}
+	if stats.RedisErrors != 3 {
+		t.Errorf("RedisErrors = %d, want 3: an open breaker should stop calling Redis", stats.RedisErrors)
+	}
+	if stats.FallbackAllowed != 10 {
+		t.Errorf("FallbackAllowed = %d, want 10", stats.FallbackAllowed)
+	}
+
+	mr.SetError("")
+	time.Sleep(60 * time.Millisecond)
+	if d, err := rl.Allow(ctx, "client"); err != nil || !d.Allowed {
+		t.Fatalf("probe after recovery: allowed=%v err=%v", d.Allowed, err)
+	}
+	if stats := rl.Stats(); stats.BreakerOpen {
+		t.Errorf("breaker still open after a successful probe: %+v", stats)
+	}
+	if stats := rl.Stats(); stats.FallbackAllowed != 10 {
+		t.Errorf("request after recovery used the fallback: %+v", stats)
+	}
+}
+
+func TestCanceledRequestsDontTripBreaker(t *testing.T) {
+	rl, _ := newLimiter(t, 100, time.Minute, middlewares.SlidingLog)
+	rl.WithBreaker(middlewares.BreakerOptions{FailureThreshold: 1, Cooldown: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for range 5 {
+		if d, err := rl.Allow(ctx, "client"); err != nil || !d.Allowed {
+			t.Fatalf("allowed=%v err=%v", d.Allowed, err)
+		}
+	}
+	if stats := rl.Stats(); stats.BreakerOpen || stats.RedisErrors != 0 || stats.FallbackAllowed != 0 {
+		t.Errorf("a client hanging up counted against Redis: %+v", stats)
+	}
+}
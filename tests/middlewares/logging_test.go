@@ -0,0 +1,229 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func newAccessLog(t *testing.T, opts middlewares.AccessLogOptions) *middlewares.AccessLog {
+	t.Helper()
+	if opts.Path == "" {
+		opts.Path = filepath.Join(t.TempDir(), "access.log")
+	}
+	if opts.OnFull == "" {
+		opts.OnFull = middlewares.AccessLogDrop
+	}
+	al, err := middlewares.NewAccessLog(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { al.Close(context.Background()) })
+	return al
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("hello"))
+})
+
+func TestAccessLogCloseDrains(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	al := newAccessLog(t, middlewares.AccessLogOptions{Path: path, Format: middlewares.AccessLogCommon, BufferSize: 100})
+
+	handler := al.Middleware(okHandler)
+	for range 10 {
+		serve(handler, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	}
+
+	report := al.Close(context.Background())
+	if report.Dropped != 0 {
+		t.Errorf("dropped %d entries with room in the buffer", report.Dropped)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 10 {
+		t.Errorf("log has %d lines, want 10", lines)
+	}
+	info, _ := os.Stat(path)
+	if perm := info.Mode().Perm(); perm&0o022 != 0 {
+		t.Errorf("log file is writable by others: %v", perm)
+	}
+
+	// Requests finishing after Close must not panic on the closed channel.
+	if rec := serve(handler, httptest.NewRequest(http.MethodGet, "/late", nil)); rec.Code != http.StatusOK {
+		t.Errorf("late request: status %d", rec.Code)
+	}
+	if got := al.Stats().Dropped; got != 1 {
+		t.Errorf("late entry not counted as dropped: %d", got)
+	}
+	if again := al.Close(context.Background()); again != (middlewares.LogReport{}) {
+		t.Errorf("second close reported %+v", again)
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{middlewares.AccessLogCommon, []string{`192.0.2.1 - - [`, `] "GET /abc?x=1 HTTP/1.1" 200 5 "req-1"` + "\n"}},
+		{middlewares.AccessLogCombined, []string{`"GET /abc?x=1 HTTP/1.1" 200 5 "https://ref.example/" "test-agent" "req-1"`}},
+		{middlewares.AccessLogJSON, []string{`"method":"GET"`, `"uri":"/abc?x=1"`, `"status":200`, `"bytes":5`, `"user_agent":"test-agent"`, `"request_id":"req-1"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			al := newAccessLog(t, middlewares.AccessLogOptions{Path: path, Format: tt.format, BufferSize: 10})
+
+			req := httptest.NewRequest(http.MethodGet, "/abc?x=1", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("Referer", "https://ref.example/")
+			req.Header.Set("User-Agent", "test-agent")
+			req.Header.Set(middlewares.RequestIDHeader, "req-1")
+			serve(middlewares.RequestID(al.Middleware(okHandler)), req)
+			al.Close(context.Background())
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("entry %q doesn't contain %q", data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	al := newAccessLog(t, middlewares.AccessLogOptions{
+		Path:       path,
+		Format:     middlewares.AccessLogCommon,
+		BufferSize: 100,
+		Rotation:   middlewares.RotationOptions{MaxSize: 200, MaxBackups: 2, Compress: true},
+	})
+
+	handler := al.Middleware(okHandler)
+	for range 20 {
+		serve(handler, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	}
+	al.Close(context.Background())
+
+	if al.Stats().Rotations == 0 {
+		t.Fatal("log never rotated")
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("kept %d backups, want 2: %v", len(backups), backups)
+	}
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup, ".gz") {
+			t.Errorf("backup %s not compressed", backup)
+			continue
+		}
+		f, err := os.Open(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", backup, err)
+		}
+		data, _ := io.ReadAll(zr)
+		f.Close()
+		if !strings.Contains(string(data), `"GET /abc HTTP/1.1"`) {
+			t.Errorf("%s holds %q", backup, data)
+		}
+	}
+}
+
+func TestAccessLogDropsWhenFull(t *testing.T) {
+	al := newAccessLog(t, middlewares.AccessLogOptions{Format: middlewares.AccessLogJSON, BufferSize: 1})
+	handler := al.Middleware(okHandler)
+	for range 1000 {
+		serve(handler, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	}
+	al.Close(context.Background())
+
+	stats := al.Stats()
+	if stats.Written+stats.Dropped != 1000 {
+		t.Errorf("written %d + dropped %d != 1000", stats.Written, stats.Dropped)
+	}
+}
+
+func TestAccessLogRejectsBadOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if _, err := middlewares.NewAccessLog(middlewares.AccessLogOptions{Path: path, Format: "apache", OnFull: middlewares.AccessLogDrop}); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+	if _, err := middlewares.NewAccessLog(middlewares.AccessLogOptions{Path: path, Format: middlewares.AccessLogJSON, OnFull: "wait"}); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestRequestLoggerAddsFields(t *testing.T) {
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	if err := utils.SetupLogger(&buf, utils.LogFormatJSON, "info"); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := middlewares.RequestID(middlewares.RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.AddLogFields(r.Context(), slog.String("short_code", "abc"))
+		w.WriteHeader(http.StatusNotFound)
+	})))
+	serve(handler, httptest.NewRequest(http.MethodGet, "/abc", nil))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("not JSON: %q", buf.String())
+	}
+	if record["status"] != float64(http.StatusNotFound) || record["short_code"] != "abc" || record["request_id"] == "" || record["request_id"] == nil {
+		t.Errorf("unexpected record: %v", record)
+	}
+}
+
+func TestRequestIDReusedOrGenerated(t *testing.T) {
+	var seen string
+	handler := middlewares.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.GetReqID(r.Context())
+		http.Error(w, "nope", http.StatusBadRequest)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(middlewares.RequestIDHeader, "3f2a-client-id")
+	rec := serve(handler, req)
+	if got := rec.Header().Get(middlewares.RequestIDHeader); got != "3f2a-client-id" || seen != got {
+		t.Errorf("response ID = %q, context ID = %q, want the caller's", got, seen)
+	}
+
+	for _, incoming := range []string{"", "has spaces\nand newline", strings.Repeat("a", 200)} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(middlewares.RequestIDHeader, incoming)
+		rec := serve(handler, req)
+		got := rec.Header().Get(middlewares.RequestIDHeader)
+		if got == "" || got == incoming || seen != got {
+			t.Errorf("for %q: response ID = %q, context ID = %q, want a new one", incoming, got, seen)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	auth "github.com/Moukhtar-youssef/URL_Shortner.git/pkg/Auth"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newLimiters(t *testing.T, policies ...middlewares.Policy) *middlewares.RateLimiters {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	mr.SetTime(start)
+	rls, err := middlewares.NewRateLimiters(mr.Addr(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rls.Close() })
+	return rls
+}
+
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestPolicyHeaders(t *testing.T) {
+	rls := newLimiters(t, middlewares.Policy{
+		Name: "create", Rate: 2, Window: time.Minute,
+		Algorithm: middlewares.SlidingLog, KeyBy: []string{middlewares.KeyByIP},
+	})
+	handler := rls.Middleware("create")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []string{"1", "0"} {
+		rec := serve(handler, httptest.NewRequest(http.MethodPost, "/create", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i, rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != want {
+			t.Errorf("request %d: RateLimit-Remaining = %q, want %q", i, got, want)
+		}
+		if got := rec.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: RateLimit-Limit = %q, want 2", i, got)
+		}
+	}
+
+	rec := serve(handler, httptest.NewRequest(http.MethodPost, "/create", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if got := rec.Header().Get("RateLimit-Reset"); got != "60" {
+		t.Errorf("RateLimit-Reset = %q, want 60", got)
+	}
+}
+
+func TestPolicyKeys(t *testing.T) {
+	rls := newLimiters(t,
+		middlewares.Policy{
+			Name: "per-user", Rate: 1, Window: time.Minute,
+			Algorithm: middlewares.GCRA, KeyBy: []string{middlewares.KeyByIdentity},
+		},
+		middlewares.Policy{
+			Name: "per-user-ip", Rate: 1, Window: time.Minute,
+			Algorithm: middlewares.GCRA, KeyBy: []string{middlewares.KeyByUser, middlewares.KeyByIP},
+		},
+	)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(user int64, ip string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		if user != 0 {
+			r = r.WithContext(auth.WithIdentity(r.Context(), auth.Identity{UserID: user}))
+		}
+		return r
+	}
+
+	perUser := rls.Middleware("per-user")(ok)
+	if rec := serve(perUser, request(1, "10.0.0.1")); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d", rec.Code)
+	}
+	if rec := serve(perUser, request(1, "10.0.0.2")); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("same user from another IP: status = %d, want 429", rec.Code)
+	}
+	if rec := serve(perUser, request(2, "10.0.0.1")); rec.Code != http.StatusOK {
+		t.Errorf("another user: status = %d, want 200", rec.Code)
+	}
+	if rec := serve(perUser, request(0, "10.0.0.1")); rec.Code != http.StatusOK {
+		t.Errorf("anonymous caller: status = %d, want 200", rec.Code)
+	}
+
+	perUserIP := rls.Middleware("per-user-ip")(ok)
+	serve(perUserIP, request(1, "10.0.0.1"))
+	if rec := serve(perUserIP, request(1, "10.0.0.2")); rec.Code != http.StatusOK {
+		t.Errorf("same user from another IP: status = %d, want 200", rec.Code)
+	}
+	if rec := serve(perUserIP, request(1, "10.0.0.1")); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("same user and IP: status = %d, want 429", rec.Code)
+	}
+}
+
+func TestUnknownPolicyIsUnlimited(t *testing.T) {
+	rls := newLimiters(t)
+	handler := rls.Middleware("missing")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range 3 {
+		if rec := serve(handler, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+	}
+}
+
+func TestNewRateLimitersValidates(t *testing.T) {
+	valid := middlewares.Policy{
+		Name: "create", Rate: 1, Window: time.Minute,
+		Algorithm: middlewares.GCRA, KeyBy: []string{middlewares.KeyByIP},
+	}
+	tests := map[string][]middlewares.Policy{
+		"no name":           {{Rate: 1, Window: time.Minute, Algorithm: middlewares.GCRA, KeyBy: []string{"ip"}}},
+		"zero rate":         {{Name: "x", Window: time.Minute, Algorithm: middlewares.GCRA, KeyBy: []string{"ip"}}},
+		"unknown algorithm": {{Name: "x", Rate: 1, Window: time.Minute, Algorithm: "leaky", KeyBy: []string{"ip"}}},
+		"unknown key":       {{Name: "x", Rate: 1, Window: time.Minute, Algorithm: middlewares.GCRA, KeyBy: []string{"cookie"}}},
+		"duplicate":         {valid, valid},
+	}
+	for name, policies := range tests {
+		if _, err := middlewares.NewRateLimiters("localhost:0", policies); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRateLimitersUpdate(t *testing.T) {
+	policy := middlewares.Policy{Name: "create", Rate: 1, Window: time.Minute, Algorithm: middlewares.SlidingLog, KeyBy: []string{middlewares.KeyByIP}}
+	rls := newLimiters(t, policy)
+	handler := rls.Middleware("create")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodPost, "/create", nil)
+
+	if rec := serve(handler, req); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	if rec := serve(handler, req); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rec.Code)
+	}
+
+	policy.Rate = 5
+	if err := rls.Update([]middlewares.Policy{policy}); err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(handler, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("after raising the limit: status %d", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Limit"); got != "5" {
+		t.Errorf("RateLimit-Limit = %q, want 5", got)
+	}
+
+	if err := rls.Update([]middlewares.Policy{{Name: "create"}}); err == nil {
+		t.Error("expected an invalid policy to be rejected")
+	}
+	if err := rls.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(handler, req); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Error("a removed policy should stop limiting the route")
+	}
+}
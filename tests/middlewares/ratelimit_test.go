@@ -0,0 +1,173 @@
+package middlewares_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+	"github.com/alicebob/miniredis/v2"
+)
+
+var algorithms = []middlewares.Algorithm{
+	middlewares.SlidingLog,
+	middlewares.SlidingWindow,
+	middlewares.GCRA,
+}
+
+// start is the beginning of a window, so SlidingWindow has no previous window
+// to weigh.
+var start = time.Unix(1_700_000_000, 0).Truncate(time.Minute)
+
+// newLimiter returns a limiter on a fresh miniredis whose clock is at start.
+func newLimiter(t *testing.T, rate int, window time.Duration, algorithm middlewares.Algorithm) (*middlewares.Ratelimiter, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	mr.SetTime(start)
+	rl := middlewares.NewRateLimiter(mr.Addr(), rate, window, algorithm)
+	t.Cleanup(func() { rl.Close() })
+	return rl, mr
+}
+
+func TestAllowNeverExceedsLimitConcurrently(t *testing.T) {
+	for _, algorithm := range algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			rl, _ := newLimiter(t, 10, time.Minute, algorithm)
+
+			var allowed atomic.Int32
+			var wg sync.WaitGroup
+			for range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					d, err := rl.Allow(context.Background(), "client")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if d.Allowed {
+						allowed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if got := allowed.Load(); got != 10 {
+				t.Errorf("allowed %d of 50 concurrent requests, want 10", got)
+			}
+		})
+	}
+}
+
+func TestAllowReportsRemainingAndRetryAfter(t *testing.T) {
+	for _, algorithm := range algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			rl, _ := newLimiter(t, 3, time.Minute, algorithm)
+			ctx := context.Background()
+
+			for i, want := range []int{2, 1, 0} {
+				d, err := rl.Allow(ctx, "client")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !d.Allowed || d.Remaining != want {
+					t.Fatalf("request %d: allowed=%v remaining=%d, want allowed with %d left", i, d.Allowed, d.Remaining, want)
+				}
+			}
+			d, err := rl.Allow(ctx, "client")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Allowed {
+				t.Fatal("request over the limit was allowed")
+			}
+			if d.RetryAfter <= 0 || d.RetryAfter > time.Minute {
+				t.Errorf("RetryAfter = %s, want within the window", d.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestAllowRecoversAfterWindow(t *testing.T) {
+	for _, algorithm := range algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			rl, mr := newLimiter(t, 2, time.Minute, algorithm)
+			ctx := context.Background()
+
+			for range 3 {
+				rl.Allow(ctx, "client")
+			}
+			if d, _ := rl.Allow(ctx, "client"); d.Allowed {
+				t.Fatal("limit not enforced")
+			}
+			if d, _ := rl.Allow(ctx, "other"); !d.Allowed {
+				t.Fatal("keys are not limited independently")
+			}
+
+			// Two full windows, so SlidingWindow's previous window is empty too.
+			mr.SetTime(start.Add(2 * time.Minute))
+			mr.FastForward(2 * time.Minute)
+			if d, err := rl.Allow(ctx, "client"); err != nil || !d.Allowed {
+				t.Fatalf("request after the window: allowed=%v err=%v", d.Allowed, err)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowWeighsPreviousWindow(t *testing.T) {
+	rl, mr := newLimiter(t, 10, time.Minute, middlewares.SlidingWindow)
+	ctx := context.Background()
+	for range 10 {
+		rl.Allow(ctx, "client")
+	}
+
+	// Halfway into the next window half of the previous window still counts,
+	// leaving room for 5 requests.
+	mr.SetTime(start.Add(90 * time.Second))
+	allowed := 0
+	for range 10 {
+		if d, _ := rl.Allow(ctx, "client"); d.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("allowed %d requests half a window later, want 5", allowed)
+	}
+}
+
+func TestGCRARefillsGradually(t *testing.T) {
+	rl, mr := newLimiter(t, 6, time.Minute, middlewares.GCRA)
+	ctx := context.Background()
+	for range 6 {
+		rl.Allow(ctx, "client")
+	}
+	if d, _ := rl.Allow(ctx, "client"); d.Allowed || d.RetryAfter != 10*time.Second {
+		t.Fatalf("empty bucket: allowed=%v retry=%s, want denied for 10s", d.Allowed, d.RetryAfter)
+	}
+
+	// One token comes back every 10s.
+	mr.SetTime(start.Add(10 * time.Second))
+	if d, _ := rl.Allow(ctx, "client"); !d.Allowed {
+		t.Fatal("token was not refilled")
+	}
+	if d, _ := rl.Allow(ctx, "client"); d.Allowed {
+		t.Fatal("more than one token was refilled")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	rl, _ := newLimiter(t, 1, time.Minute, middlewares.SlidingLog)
+	handler := middlewares.RateLimitMiddleware(rl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i, rec.Code, want)
+		}
+	}
+}
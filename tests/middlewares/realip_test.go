@@ -0,0 +1,47 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/middlewares"
+)
+
+func TestRealIPOnlyTrustsConfiguredProxies(t *testing.T) {
+	trusted, err := middlewares.ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	handler := middlewares.RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.RemoteAddr
+	}))
+
+	tests := []struct {
+		name   string
+		peer   string
+		header string
+		value  string
+		want   string
+	}{
+		{"untrusted peer", "203.0.113.9:4000", "X-Forwarded-For", "198.51.100.1", "203.0.113.9:4000"},
+		{"trusted proxy", "10.1.2.3:4000", "X-Forwarded-For", "198.51.100.1", "198.51.100.1"},
+		{"spoofed hops", "10.1.2.3:4000", "X-Forwarded-For", "1.2.3.4, 198.51.100.1, 10.9.9.9", "198.51.100.1"},
+		{"single address", "192.0.2.7:4000", "X-Real-IP", "198.51.100.2", "198.51.100.2"},
+		{"garbage", "10.1.2.3:4000", "X-Forwarded-For", "not-an-ip", "10.1.2.3:4000"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.peer
+		req.Header.Set(tt.header, tt.value)
+		serve(handler, req)
+		if seen != tt.want {
+			t.Errorf("%s: RemoteAddr = %q, want %q", tt.name, seen, tt.want)
+		}
+	}
+
+	if _, err := middlewares.ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an invalid range to be rejected")
+	}
+}
@@ -0,0 +1,158 @@
+package storage_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+func TestCacheExpiresOnRead(t *testing.T) {
+	c := Storage.NewCache(Storage.CacheOptions{Shards: 1})
+	defer c.Stop()
+
+	c.Set("short", "https://example.com", 20*time.Millisecond)
+	if v, ok := c.Get("short"); !ok || v != "https://example.com" {
+		t.Fatalf("Get = %q, %v before expiry", v, ok)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("short"); ok {
+		t.Fatal("Get returned an expired entry")
+	}
+	if stats := c.Stats(); stats.Expirations != 1 || stats.Entries != 0 {
+		t.Errorf("stats after expiry = %+v", stats)
+	}
+}
+
+func TestCacheGetWithExpiry(t *testing.T) {
+	c := Storage.NewCache(Storage.CacheOptions{Shards: 1})
+	defer c.Stop()
+
+	before := time.Now()
+	c.Set("short", "https://example.com", time.Minute)
+	_, expiry, ok := c.GetWithExpiry("short")
+	if !ok {
+		t.Fatal("GetWithExpiry missed a fresh entry")
+	}
+	if expiry.Before(before.Add(time.Minute)) || expiry.After(time.Now().Add(time.Minute)) {
+		t.Errorf("expiry = %v, want about a minute from now", expiry)
+	}
+	if _, _, ok := c.GetWithExpiry("missing"); ok {
+		t.Error("GetWithExpiry hit a missing key")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := Storage.NewCache(Storage.CacheOptions{MaxEntries: 3, Shards: 1})
+	defer c.Stop()
+
+	for _, k := range []string{"a", "b", "c"} {
+		c.Set(k, k, time.Minute)
+	}
+	c.Get("a") // a is now the most recently used
+	c.Set("d", "d", time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("%s should still be cached", k)
+		}
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 3 {
+		t.Errorf("stats = %+v, want 1 eviction and 3 entries", stats)
+	}
+}
+
+func TestCacheRespectsMaxBytes(t *testing.T) {
+	c := Storage.NewCache(Storage.CacheOptions{MaxBytes: 4096, Shards: 1})
+	defer c.Stop()
+
+	value := strings.Repeat("x", 500)
+	for i := range 100 {
+		c.Set(fmt.Sprintf("key%d", i), value, time.Minute)
+	}
+	stats := c.Stats()
+	if stats.Bytes > 4096 {
+		t.Errorf("cache holds %d bytes, limit is 4096", stats.Bytes)
+	}
+	if stats.Entries == 0 || stats.Evictions == 0 {
+		t.Errorf("stats = %+v, expected some entries and evictions", stats)
+	}
+
+	c.Set("huge", strings.Repeat("y", 5000), time.Minute)
+	if _, ok := c.Get("huge"); ok {
+		t.Error("an entry larger than the whole cache was stored")
+	}
+
+	// Updating a cached key to a value too big to cache drops the old value.
+	c.Set("key99", strings.Repeat("z", 5000), time.Minute)
+	if v, ok := c.Get("key99"); ok {
+		t.Errorf("Get = %.10q after an oversized update, want a miss", v)
+	}
+}
+
+func TestCacheOverwriteAndDelete(t *testing.T) {
+	c := Storage.NewCache(Storage.DefaultCacheOptions)
+	defer c.Stop()
+
+	c.Set("k", "old", time.Minute)
+	c.Set("k", "new", time.Minute)
+	if v, _ := c.Get("k"); v != "new" {
+		t.Errorf("Get = %q, want new", v)
+	}
+	c.Delete("k")
+	if _, ok := c.Get("k"); ok {
+		t.Error("deleted key is still cached")
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Bytes != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	c := Storage.NewCache(Storage.CacheOptions{MaxEntries: 1000, Shards: 16})
+	defer c.Stop()
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 5000 {
+				key := fmt.Sprintf("k%d", (i*7+g)%2000)
+				if i%3 == 0 {
+					c.Set(key, key, time.Minute)
+				} else {
+					c.Get(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := c.Len(); n > 1000 {
+		t.Errorf("cache grew to %d entries, limit is 1000", n)
+	}
+}
+
+func BenchmarkCacheParallelGet(b *testing.B) {
+	c := Storage.NewCache(Storage.DefaultCacheOptions)
+	defer c.Stop()
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("code%03d", i)
+		c.Set(keys[i], "https://example.com/"+keys[i], time.Hour)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(keys[i&1023])
+			i++
+		}
+	})
+}
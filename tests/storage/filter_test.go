@@ -0,0 +1,38 @@
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+func TestBloomFilterHasNoFalseNegatives(t *testing.T) {
+	f := Storage.NewBloomFilter(10000, 0.01)
+	for i := range 10000 {
+		f.Add(fmt.Sprintf("code%d", i))
+	}
+	for i := range 10000 {
+		if key := fmt.Sprintf("code%d", i); !f.MayContain(key) {
+			t.Fatalf("MayContain(%q) = false for an added key", key)
+		}
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	f := Storage.NewBloomFilter(10000, 0.01)
+	for i := range 10000 {
+		f.Add(fmt.Sprintf("code%d", i))
+	}
+	falsePositives := 0
+	const probes = 100000
+	for i := range probes {
+		if f.MayContain(fmt.Sprintf("missing%d", i)) {
+			falsePositives++
+		}
+	}
+	// Allow twice the target rate for slack.
+	if rate := float64(falsePositives) / probes; rate > 0.02 {
+		t.Errorf("false positive rate = %.4f, want about 0.01", rate)
+	}
+}
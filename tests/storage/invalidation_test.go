@@ -0,0 +1,184 @@
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeSource stands in for Postgres and counts how often the lookup path
+// falls through to it.
+type fakeSource struct {
+	mu      sync.Mutex
+	links   map[string]string
+	expires map[string]time.Time
+	// gate, if set, holds every Link call until it is closed.
+	gate  chan struct{}
+	calls atomic.Int64
+}
+
+func newFakeSource(links map[string]string) *fakeSource {
+	if links == nil {
+		links = map[string]string{}
+	}
+	return &fakeSource{links: links, expires: map[string]time.Time{}}
+}
+
+func (src *fakeSource) Link(ctx context.Context, short string) (string, *time.Time, error) {
+	src.calls.Add(1)
+	if src.gate != nil {
+		select {
+		case <-src.gate:
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		}
+	}
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	long, ok := src.links[short]
+	if !ok {
+		return "", nil, Storage.ErrNotFound
+	}
+	if expiresAt, ok := src.expires[short]; ok {
+		return long, &expiresAt, nil
+	}
+	return long, nil, nil
+}
+
+func (src *fakeSource) CountCodes(ctx context.Context) (int, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return len(src.links), nil
+}
+
+func (src *fakeSource) ScanCodes(ctx context.Context, add func(string)) error {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	for short := range src.links {
+		add(short)
+	}
+	return nil
+}
+
+func (src *fakeSource) set(short, long string) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.links[short] = long
+}
+
+func (src *fakeSource) remove(short string) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	delete(src.links, short)
+}
+
+// newTestDB returns storage on a fresh miniredis with src in place of
+// Postgres, once its invalidation bus has connected.
+func newTestDB(t *testing.T, src Storage.LinkSource) (*Storage.URLDB, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	DB := Storage.NewURLDB(redis.NewClient(&redis.Options{Addr: mr.Addr()}), src, Storage.DefaultOptions)
+	t.Cleanup(func() { DB.Close() })
+	waitConnected(t, DB)
+	return DB, mr
+}
+
+func waitConnected(t *testing.T, DB *Storage.URLDB) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := DB.WaitInvalidationConnected(ctx); err != nil {
+		t.Fatalf("invalidation bus never connected: %v", err)
+	}
+}
+
+func TestInvalidationBusFirstConnect(t *testing.T) {
+	mr := miniredis.RunT(t)
+	DB := Storage.NewURLDB(redis.NewClient(&redis.Options{Addr: mr.Addr()}), newFakeSource(nil), Storage.DefaultOptions)
+	defer DB.Close()
+
+	start := time.Now()
+	waitConnected(t, DB)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitInvalidationConnected took %v after the first subscribe", elapsed)
+	}
+	if !DB.InvalidationConnected() {
+		t.Error("bus connected but not reported as connected")
+	}
+}
+
+// eventually polls cond until it holds or three seconds have passed, which
+// covers the bus's first reconnect backoff.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInvalidationFromAnotherReplicaEvicts(t *testing.T) {
+	DB, mr := newTestDB(t, newFakeSource(nil))
+	DB.Cache.Set("abc", "https://example.com/old", time.Minute)
+
+	mr.Publish("cache:invalidate", "other-replica|del|abc")
+	eventually(t, "the entry to be evicted", func() bool {
+		_, ok := DB.Cache.Get("abc")
+		return !ok
+	})
+}
+
+func TestInvalidationIgnoresOwnMessages(t *testing.T) {
+	mr := miniredis.RunT(t)
+	replicas := make([]*Storage.URLDB, 2)
+	for i := range replicas {
+		replicas[i] = Storage.NewURLDB(redis.NewClient(&redis.Options{Addr: mr.Addr()}), newFakeSource(nil), Storage.DefaultOptions)
+		t.Cleanup(func() { replicas[i].Close() })
+		waitConnected(t, replicas[i])
+	}
+	a, b := replicas[0], replicas[1]
+	b.Cache.Set("abc", "https://example.com/old", time.Minute)
+
+	// a re-caches the new value right after announcing the change; its own
+	// message arriving afterwards must not evict it.
+	a.Invalidate(context.Background(), "abc")
+	a.Cache.Set("abc", "https://example.com/new", time.Minute)
+
+	eventually(t, "the other replica to evict", func() bool {
+		_, ok := b.Cache.Get("abc")
+		return !ok
+	})
+	time.Sleep(20 * time.Millisecond)
+	if v, ok := a.Cache.Get("abc"); !ok || v != "https://example.com/new" {
+		t.Errorf("sender's entry = %q, %v; its own message evicted it", v, ok)
+	}
+}
+
+func TestInvalidationPurgesAfterReconnect(t *testing.T) {
+	DB, mr := newTestDB(t, newFakeSource(nil))
+
+	mr.Close()
+	eventually(t, "the bus to notice the disconnect", func() bool {
+		return !DB.InvalidationConnected()
+	})
+	// Cached while deaf to other replicas, so possibly stale by the time the
+	// bus is back.
+	DB.Cache.Set("abc", "https://example.com/maybe-stale", time.Minute)
+
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the reconnect to purge the cache", func() bool {
+		_, ok := DB.Cache.Get("abc")
+		return !ok
+	})
+}
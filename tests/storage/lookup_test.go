@@ -0,0 +1,100 @@
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentMissesShareOneFetch(t *testing.T) {
+	src := newFakeSource(map[string]string{"abc": "https://example.com"})
+	src.gate = make(chan struct{})
+	DB, _ := newTestDB(t, src)
+
+	const callers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			long, err := DB.GetURL(context.Background(), "abc")
+			if err == nil && long != "https://example.com" {
+				t.Errorf("GetURL = %q", long)
+			}
+			errs <- err
+		}()
+	}
+	// Hold the fetch until every caller has had time to pile up behind it.
+	time.Sleep(50 * time.Millisecond)
+	close(src.gate)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := src.calls.Load(); n != 1 {
+		t.Errorf("%d Postgres fetches for %d concurrent misses, want 1", n, callers)
+	}
+	stats := DB.LookupStats()
+	if stats.RedisMisses != 1 || stats.Coalesced != callers-1 {
+		t.Errorf("stats = %+v, want one Redis miss and %d coalesced", stats, callers-1)
+	}
+
+	// Now cached locally: no more fetches.
+	if _, err := DB.GetURL(context.Background(), "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if n := src.calls.Load(); n != 1 {
+		t.Errorf("%d Postgres fetches after the code was cached, want 1", n)
+	}
+}
+
+func TestHotKeyIsRefreshedEarlyOnce(t *testing.T) {
+	src := newFakeSource(map[string]string{"abc": "https://example.com/new"})
+	src.gate = make(chan struct{})
+	DB, _ := newTestDB(t, src)
+	// A few milliseconds from expiry, every read is almost sure to ask for a
+	// refresh.
+	DB.Cache.Set("abc", "https://example.com/old", 200*time.Millisecond)
+
+	for range 50 {
+		long, err := DB.GetURL(context.Background(), "abc")
+		if err != nil || long != "https://example.com/old" {
+			t.Fatalf("GetURL = %q, %v; want the cached value while refreshing", long, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(src.gate)
+
+	eventually(t, "the refreshed value", func() bool {
+		long, ok := DB.Cache.Get("abc")
+		return ok && long == "https://example.com/new"
+	})
+	if n := src.calls.Load(); n != 1 {
+		t.Errorf("%d fetches for one hot key, want 1", n)
+	}
+	if n := DB.LookupStats().EarlyRefreshes; n != 1 {
+		t.Errorf("EarlyRefreshes = %d, want 1", n)
+	}
+}
+
+func TestFreshEntryIsNotRefreshed(t *testing.T) {
+	src := newFakeSource(map[string]string{"abc": "https://example.com"})
+	DB, _ := newTestDB(t, src)
+	DB.Cache.Set("abc", "https://example.com", time.Hour)
+
+	for range 100 {
+		if _, err := DB.GetURL(context.Background(), "abc"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := src.calls.Load(); n != 0 || DB.LookupStats().EarlyRefreshes != 0 {
+		t.Errorf("%d fetches and %d refreshes for an entry far from expiry", n, DB.LookupStats().EarlyRefreshes)
+	}
+}
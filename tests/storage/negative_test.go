@@ -0,0 +1,104 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+// waitFilterReady probes fresh unknown codes until the code filter answers
+// one, which it only does once it has loaded every code.
+func waitFilterReady(t *testing.T, DB *Storage.URLDB) {
+	t.Helper()
+	i := 0
+	eventually(t, "the code filter to load", func() bool {
+		i++
+		DB.GetURL(context.Background(), fmt.Sprintf("probe%d", i))
+		return DB.LookupStats().FilterRejects > 0
+	})
+}
+
+func TestFilterAnswersUnknownCodesWithoutFetching(t *testing.T) {
+	src := newFakeSource(map[string]string{"abc": "https://example.com"})
+	DB, _ := newTestDB(t, src)
+	waitFilterReady(t, DB)
+
+	before := src.calls.Load()
+	for i := range 100 {
+		if _, err := DB.GetURL(context.Background(), fmt.Sprintf("unknown%d", i)); !errors.Is(err, Storage.ErrNotFound) {
+			t.Fatalf("GetURL of an unknown code: %v, want ErrNotFound", err)
+		}
+	}
+	// The filter has a 1% false positive rate, and those fall through.
+	if n := src.calls.Load() - before; n > 5 {
+		t.Errorf("%d fetches for 100 unknown codes", n)
+	}
+	if long, err := DB.GetURL(context.Background(), "abc"); err != nil || long != "https://example.com" {
+		t.Errorf("GetURL of a known code = %q, %v", long, err)
+	}
+}
+
+func TestMissingCodeIsRemembered(t *testing.T) {
+	// "gone" is in the filter but no longer in the store, as after a delete
+	// or a false positive.
+	src := newFakeSource(map[string]string{"gone": "https://example.com"})
+	DB, _ := newTestDB(t, src)
+	waitFilterReady(t, DB)
+	src.remove("gone")
+
+	before := src.calls.Load()
+	for range 3 {
+		if _, err := DB.GetURL(context.Background(), "gone"); !errors.Is(err, Storage.ErrNotFound) {
+			t.Fatalf("GetURL: %v, want ErrNotFound", err)
+		}
+	}
+	if n := src.calls.Load() - before; n != 1 {
+		t.Errorf("%d fetches for a missing code, want 1", n)
+	}
+	if n := DB.LookupStats().NegativeHits; n != 2 {
+		t.Errorf("NegativeHits = %d, want 2", n)
+	}
+}
+
+func TestExpiredCodeIsRememberedAsExpired(t *testing.T) {
+	src := newFakeSource(map[string]string{"old": "https://example.com"})
+	src.expires["old"] = time.Now().Add(-time.Hour)
+	DB, _ := newTestDB(t, src)
+
+	for range 3 {
+		if _, err := DB.GetURL(context.Background(), "old"); !errors.Is(err, Storage.ErrExpired) {
+			t.Fatalf("GetURL: %v, want ErrExpired", err)
+		}
+	}
+	if n := src.calls.Load(); n != 1 {
+		t.Errorf("%d fetches for an expired code, want 1", n)
+	}
+}
+
+func TestCreateClearsRememberedMiss(t *testing.T) {
+	// Loaded into the filter, then deleted, so the miss below ends up in the
+	// negative cache rather than being answered by the filter.
+	src := newFakeSource(map[string]string{"new": "https://example.com/old"})
+	DB, mr := newTestDB(t, src)
+	waitFilterReady(t, DB)
+	src.remove("new")
+
+	if _, err := DB.GetURL(context.Background(), "new"); !errors.Is(err, Storage.ErrNotFound) {
+		t.Fatalf("GetURL before the create: %v, want ErrNotFound", err)
+	}
+	if _, err := DB.GetURL(context.Background(), "new"); DB.LookupStats().NegativeHits != 1 {
+		t.Fatalf("miss not remembered: %v, stats %+v", err, DB.LookupStats())
+	}
+
+	// Another replica creates the code and announces it.
+	src.set("new", "https://example.com/new")
+	mr.Publish("cache:invalidate", "other-replica|add|new")
+	eventually(t, "the created code to resolve", func() bool {
+		long, err := DB.GetURL(context.Background(), "new")
+		return err == nil && long == "https://example.com/new"
+	})
+}
@@ -0,0 +1,48 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	Storage "github.com/Moukhtar-youssef/URL_Shortner.git/internal/storage"
+)
+
+func TestQuotaPeriod(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 3, 17, 12, 30, 0, 0, time.UTC), time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// 23:00 on Feb 28 in UTC-5 is already March in UTC.
+		{time.Date(2026, 2, 28, 23, 0, 0, 0, time.FixedZone("EST", -5*3600)), time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := Storage.QuotaPeriod(tt.in); !got.Equal(tt.want) {
+			t.Errorf("QuotaPeriod(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAnonymousQuotaIsPerIP(t *testing.T) {
+	DB, _ := newTestDB(t, newFakeSource(nil))
+	ctx := context.Background()
+
+	if ok, used, err := DB.ConsumeAnonymousQuota(ctx, "203.0.113.7", 3, 3); err != nil || !ok || used != 3 {
+		t.Fatalf("first consume = %v, %d, %v; want allowed with 3 used", ok, used, err)
+	}
+	if ok, used, err := DB.ConsumeAnonymousQuota(ctx, "203.0.113.7", 1, 3); err != nil || ok || used != 3 {
+		t.Fatalf("consume past the limit = %v, %d, %v; want refused with 3 used", ok, used, err)
+	}
+	if ok, _, err := DB.ConsumeAnonymousQuota(ctx, "198.51.100.1", 1, 3); err != nil || !ok {
+		t.Fatalf("another IP = %v, %v; want allowed", ok, err)
+	}
+
+	if err := DB.ReleaseAnonymousQuota(ctx, "203.0.113.7", 1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, used, err := DB.ConsumeAnonymousQuota(ctx, "203.0.113.7", 1, 3); err != nil || !ok || used != 3 {
+		t.Fatalf("consume after release = %v, %d, %v; want allowed with 3 used", ok, used, err)
+	}
+}
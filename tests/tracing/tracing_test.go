@@ -0,0 +1,132 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/tracing"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+type exportedSpan struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ TraceID, SpanID string }
+	Attributes  []struct {
+		Key   string
+		Value struct{ Value any }
+	}
+}
+
+func (s exportedSpan) attr(key string) any {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.Value
+		}
+	}
+	return nil
+}
+
+// setup exports spans to a buffer; the returned function flushes them and
+// decodes what was written.
+func setup(t *testing.T) func() []exportedSpan {
+	t.Helper()
+	var buf bytes.Buffer
+	stop, err := tracing.Setup(context.Background(), tracing.Options{
+		ServiceName: "test",
+		Exporter:    tracing.ExporterStdout,
+		SampleRatio: 1,
+		Writer:      &buf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() []exportedSpan {
+		if err := stop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		var spans []exportedSpan
+		dec := json.NewDecoder(&buf)
+		for {
+			var s exportedSpan
+			if err := dec.Decode(&s); err == io.EOF {
+				return spans
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			spans = append(spans, s)
+		}
+	}
+}
+
+func TestMiddlewareContinuesIncomingTrace(t *testing.T) {
+	flush := setup(t)
+
+	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Get("/api/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/abc123", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := flush()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /api/{id}" {
+		t.Errorf("name = %q, want the route pattern", span.Name)
+	}
+	if span.SpanContext.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s, want the caller's", span.SpanContext.TraceID)
+	}
+	if span.Parent.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("parent = %s, want the caller's span", span.Parent.SpanID)
+	}
+	if got := span.attr("http.response.status_code"); got != float64(404) {
+		t.Errorf("status attribute = %v, want 404", got)
+	}
+	if got := span.attr("http.route"); got != "/api/{id}" {
+		t.Errorf("route attribute = %v", got)
+	}
+}
+
+func TestRedisCommandsAreTraced(t *testing.T) {
+	flush := setup(t)
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	if err := tracing.InstrumentRedis(rdb); err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := tracing.Tracer().Start(context.Background(), "parent")
+	rdb.Get(ctx, "missing")
+	span.End()
+
+	spans := flush()
+	var get, parent *exportedSpan
+	for i := range spans {
+		switch spans[i].Name {
+		case "get":
+			get = &spans[i]
+		case "parent":
+			parent = &spans[i]
+		}
+	}
+	if get == nil || parent == nil {
+		t.Fatalf("spans = %+v, want get and parent", spans)
+	}
+	if get.Parent.SpanID != parent.SpanContext.SpanID {
+		t.Error("redis span is not a child of the caller's span")
+	}
+}
@@ -0,0 +1,74 @@
+package utils_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Moukhtar-youssef/URL_Shortner.git/internal/utils"
+)
+
+func setupLogger(t *testing.T, format, level string) *bytes.Buffer {
+	t.Helper()
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	if err := utils.SetupLogger(&buf, format, level); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestLoggerRequestFields(t *testing.T) {
+	buf := setupLogger(t, utils.LogFormatJSON, "info")
+
+	ctx := utils.WithLogFields(context.Background(), slog.String("request_id", "r1"))
+	utils.AddLogFields(ctx, slog.String("short_code", "abc"), slog.Int64("user_id", 7))
+	slog.InfoContext(ctx, "resolved")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("not JSON: %q", buf.String())
+	}
+	if record["msg"] != "resolved" || record["request_id"] != "r1" || record["short_code"] != "abc" || record["user_id"] != float64(7) {
+		t.Errorf("unexpected record: %v", record)
+	}
+
+	// Fields added without WithLogFields are ignored rather than panicking.
+	utils.AddLogFields(context.Background(), slog.String("lost", "x"))
+}
+
+func TestSetLogLevelAtRuntime(t *testing.T) {
+	buf := setupLogger(t, utils.LogFormatText, "warn")
+
+	slog.Info("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("info logged at warn level: %q", buf.String())
+	}
+	if err := utils.SetLogLevel("DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	slog.Debug("shown")
+	if !strings.Contains(buf.String(), "msg=shown") {
+		t.Errorf("debug not logged after lowering the level: %q", buf.String())
+	}
+
+	if err := utils.SetLogLevel("loud"); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+}
+
+func TestSetupLoggerRoutesStandardLog(t *testing.T) {
+	buf := setupLogger(t, utils.LogFormatJSON, "info")
+	log.Printf("legacy %d", 1)
+	if !strings.Contains(buf.String(), `"msg":"legacy 1"`) {
+		t.Errorf("log.Printf not routed through slog: %q", buf.String())
+	}
+	if err := utils.SetupLogger(buf, "xml", "info"); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}